@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sam01101/gocq-qqdrive/coolq"
+	"github.com/sam01101/gocq-qqdrive/global"
+	"github.com/sam01101/gocq-qqdrive/server"
+)
+
+// 子命令的退出码
+const (
+	exitOK            = 0
+	exitError         = 1
+	exitUsage         = 2
+	exitNotFound      = 3 // 本地不存在对应的记录
+	exitRemoteMissing = 4 // 服务器上的内容已不存在
+	// 登录失败时由 server 以 server.LoginFailedExitCode (5) 退出
+)
+
+const commandUsage = `usage:
+  go-cqhttp put <file>
+  go-cqhttp get <id> [dest]
+  go-cqhttp ls [-a]
+  go-cqhttp info <id>
+  go-cqhttp rm <id>
+  go-cqhttp backup [list]
+  go-cqhttp restore <snapshot> <path>`
+
+// runCommand 执行子命令并返回退出码
+func runCommand(args []string, byteKey []byte) int {
+	switch {
+	case args[0] == "put" && len(args) == 2:
+		return withBot(byteKey, func(ctx context.Context, bot *coolq.CQBot) int {
+			return printMSG(bot.CQUploadChunkedFile(ctx, args[1], 0, 0, 0, "", ""))
+		})
+	case args[0] == "get" && (len(args) == 2 || len(args) == 3):
+		dest := ""
+		if len(args) == 3 {
+			dest = args[2]
+		}
+		return withBot(byteKey, func(ctx context.Context, bot *coolq.CQBot) int {
+			return printMSG(bot.CQDownloadChunkedFile(ctx, args[1], dest))
+		})
+	case args[0] == "ls" && (len(args) == 1 || len(args) == 2 && args[1] == "-a"):
+		entries, err := coolq.ListDrive(len(args) == 2)
+		return printResult(coolq.MSG{"entries": entries}, err)
+	case args[0] == "info" && len(args) == 2:
+		return withBot(byteKey, func(_ context.Context, bot *coolq.CQBot) int {
+			return printResult(bot.DriveInfo(args[1]))
+		})
+	case args[0] == "rm" && len(args) == 2:
+		record, err := coolq.RemoveDriveRecord(args[1])
+		return printResult(coolq.MSG{"removed": record}, err)
+	case args[0] == "backup" && len(args) > 1 && args[1] == "list":
+		return backupList()
+	case args[0] == "backup":
+		return withBot(byteKey, runBackup)
+	case args[0] == "restore" && len(args) >= 3:
+		return withBot(byteKey, func(ctx context.Context, bot *coolq.CQBot) int {
+			return runRestore(ctx, bot, args[1], args[2])
+		})
+	}
+	fmt.Fprintln(os.Stderr, commandUsage)
+	return exitUsage
+}
+
+// printMSG 将 API 返回值以 JSON 格式输出到标准输出, 并根据 retcode 与 msg 返回退出码
+func printMSG(m coolq.MSG) int {
+	b, err := json.Marshal(m)
+	if err != nil {
+		log.Errorf("序列化结果时出现错误: %v", err)
+		return exitError
+	}
+	fmt.Println(string(b))
+	if m["retcode"] == 0 {
+		return exitOK
+	}
+	switch m["msg"] {
+	case "RECORD_NOT_FOUND", "FILE_NOT_FOUND":
+		return exitNotFound
+	case "REMOTE_MISSING":
+		return exitRemoteMissing
+	}
+	return exitError
+}
+
+// printResult 输出 data 或 err 对应的返回值
+func printResult(data coolq.MSG, err error) int {
+	switch {
+	case err == nil:
+		return printMSG(coolq.OK(data))
+	case errors.Is(err, coolq.ErrRecordNotFound):
+		return printMSG(coolq.Failed(100, "RECORD_NOT_FOUND", err.Error()))
+	case errors.Is(err, global.ErrRemoteMissing):
+		return printMSG(coolq.Failed(100, "REMOTE_MISSING", err.Error()))
+	}
+	return printMSG(coolq.Failed(100, "COMMAND_FAILED", err.Error()))
+}
+
+// withBot 登录后执行 f, 收到中断信号时取消 f 的 context
+func withBot(byteKey []byte, f func(ctx context.Context, bot *coolq.CQBot) int) int {
+	loadAccount(byteKey)
+	bot := server.WebServer.Login(newClient())
+	defer bot.Client.Disconnect()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		log.Warn("正在取消...")
+		cancel()
+	}()
+	return f(ctx, bot)
+}
+
+func runBackup(ctx context.Context, bot *coolq.CQBot) int {
+	c := conf.Backup
+	if c == nil || len(c.Dirs) == 0 {
+		log.Error("未配置需要备份的目录, 请修改 config.hjson 中的 backup.dirs")
+		return exitUsage
+	}
+	start := time.Now()
+	entry, stats, err := bot.Backup(ctx, c.Dirs, coolq.BackupRetention{
+		KeepLast: c.KeepLast, KeepDaily: c.KeepDaily, KeepWeekly: c.KeepWeekly, KeepMonthly: c.KeepMonthly,
+	})
+	if err != nil {
+		log.Errorf("备份失败: %v", err)
+		return exitError
+	}
+	log.Infof("快照 %v 备份完成: %v 个文件 (%v 个未变化), 分块 %v 个 (复用 %v 个), 上传 %v, 耗时 %v",
+		entry.ID, stats.Files, stats.Unchanged, stats.Parts, stats.ReusedParts,
+		humanize.Bytes(uint64(stats.UploadedSize)), time.Since(start).Truncate(time.Second))
+	return exitOK
+}
+
+func runRestore(ctx context.Context, bot *coolq.CQBot, snapshot, dest string) int {
+	snap, err := bot.Restore(ctx, snapshot, dest)
+	if err != nil {
+		log.Errorf("恢复快照 %v 失败: %v", snapshot, err)
+		return exitError
+	}
+	log.Infof("快照 %v 已恢复到 %v, 共 %v 个文件", snap.ID, dest, len(snap.Files))
+	return exitOK
+}
+
+func backupList() int {
+	entries, err := coolq.ReadBackupIndex()
+	if err != nil {
+		log.Errorf("读取快照索引失败: %v", err)
+		return exitError
+	}
+	for _, e := range entries {
+		fmt.Printf("%s  %s  %6d files  %10s  %s\n", e.ID, time.Unix(e.Time, 0).Format("2006-01-02 15:04:05"),
+			e.Files, humanize.Bytes(uint64(e.Size)), e.MessageID)
+	}
+	return exitOK
+}
@@ -2,21 +2,23 @@ package coolq
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"github.com/sam01101/MiraiGo-qdrive/binary"
+	"errors"
+	"fmt"
+	"github.com/sam01101/MiraiGo-qdrive/client"
 	"github.com/sam01101/MiraiGo-qdrive/message"
 	"github.com/tidwall/gjson"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/sam01101/gocq-qqdrive/global"
-	log "github.com/sirupsen/logrus"
 )
 
 // Version go-cqhttp的版本信息，在编译时使用ldflags进行覆盖
@@ -29,46 +31,57 @@ func (bot *CQBot) CQGetLoginInfo() MSG {
 	return OK(MSG{"user_id": bot.Client.Uin, "nickname": bot.Client.Nickname})
 }
 
-func (bot *CQBot) CQUploadShortVideo(filePath string) MSG {
-	_ = global.ExtractCover(filePath, filePath+".jpg")
-	data, _ := ioutil.ReadFile(filePath + ".jpg")
+func (bot *CQBot) CQUploadShortVideo(ctx context.Context, filePath string) MSG {
 	shortVideoElem := LocalVideoElement{
 		File:  filePath,
-		thumb: bytes.NewReader(data),
+		thumb: bytes.NewReader(videoCover(filePath)),
 	}
+	start := time.Now()
 	gv, err := bot.UploadLocalVideo(&shortVideoElem)
+	var size int64
+	if err == nil {
+		size = int64(gv.Size)
+	}
+	bot.transfer.upload(size, start, err)
 	if err != nil {
-		log.Warnf("警告: 短视频上传失败: %v", err)
+		Logger(ctx).Warnf("警告: 短视频上传失败: %v", err)
 		return Failed(100, "SHORT_VIDEO_UPLOAD_FAILED", err.Error())
 	}
-	filename := hex.EncodeToString(gv.Md5) + ".video"
-	if !global.PathExists(path.Join(global.VideoPath, filename)) {
-		_ = ioutil.WriteFile(path.Join(global.VideoPath, filename), binary.NewWriterF(func(w *binary.Writer) {
-			w.Write(gv.Md5)
-			w.Write(gv.ThumbMd5)
-			w.WriteUInt32(uint32(gv.Size))
-			w.WriteUInt32(uint32(gv.ThumbSize))
-			w.WriteString(gv.Name)
-			w.Write(gv.Uuid)
-		}), 0644)
+	filename, err := writeVideoRecord(gv, filePath)
+	if err != nil {
+		Logger(ctx).Warnf("保存视频记录时出现错误: %v", err)
 	}
+	bot.Publish(&UploadCompleteEvent{
+		Time:     time.Now().Unix(),
+		SelfID:   bot.Client.Uin,
+		FileName: filename,
+		FileMd5:  hex.EncodeToString(gv.Md5),
+		Size:     int64(gv.Size),
+	})
 	return OK(MSG{"size": gv.Size, "file_md5": gv.Md5, "file_name": filename})
 }
 
 // CQDownloadFile 扩展API-下载文件到缓存目录
 //
 // https://docs.go-cqhttp.org/api/#%E4%B8%8B%E8%BD%BD%E6%96%87%E4%BB%B6%E5%88%B0%E7%BC%93%E5%AD%98%E7%9B%AE%E5%BD%95
-func (bot *CQBot) CQDownloadFile(url string, headers map[string]string, threadCount int) MSG {
+func (bot *CQBot) CQDownloadFile(ctx context.Context, url string, headers map[string]string, threadCount int) MSG {
 	hash := md5.Sum([]byte(url))
 	file := path.Join(global.CachePath, hex.EncodeToString(hash[:])+".cache")
 	if global.PathExists(file) {
 		if err := os.Remove(file); err != nil {
-			log.Warnf("删除缓存文件 %v 时出现错误: %v", file, err)
+			Logger(ctx).Warnf("删除缓存文件 %v 时出现错误: %v", file, err)
 			return Failed(100, "DELETE_FILE_ERROR", err.Error())
 		}
 	}
-	if err := global.DownloadFileMultiThreading(url, file, 0, threadCount, headers); err != nil {
-		log.Warnf("下载链接 %v 时出现错误: %v", url, err)
+	start := time.Now()
+	err := downloadCacheFile(url, file, 0, threadCount, headers)
+	var size int64
+	if info, e := os.Stat(file); err == nil && e == nil {
+		size = info.Size()
+	}
+	bot.transfer.download(size, start, err)
+	if err != nil {
+		Logger(ctx).Warnf("下载链接 %v 时出现错误: %v", url, err)
 		return Failed(100, "DOWNLOAD_FILE_ERROR", err.Error())
 	}
 	abs, _ := filepath.Abs(file)
@@ -77,14 +90,89 @@ func (bot *CQBot) CQDownloadFile(url string, headers map[string]string, threadCo
 	})
 }
 
+// CQUploadChunkedFile 扩展API-分块上传文件
+//
+// 参数为0或空时使用配置文件中的默认值
+func (bot *CQBot) CQUploadChunkedFile(ctx context.Context, file string, partSize int64, dataShards, parityShards int, compression, chunking string) MSG {
+	opt := bot.chunkOpts
+	if partSize > 0 {
+		opt.PartSize = partSize
+	}
+	if dataShards > 0 {
+		opt.DataShards = dataShards
+	}
+	if parityShards > 0 {
+		opt.ParityShards = parityShards
+	}
+	if compression != "" {
+		opt.Compression = compression
+	}
+	if chunking != "" {
+		opt.Chunking = chunking
+	}
+	m, resID, stats, err := bot.UploadChunkedFile(ctx, file, opt)
+	if err != nil {
+		Logger(ctx).Warnf("分块上传文件 %v 时出现错误: %v", file, err)
+		if os.IsNotExist(err) {
+			return Failed(100, "FILE_NOT_FOUND", err.Error())
+		}
+		return Failed(100, "CHUNKED_UPLOAD_FAILED", err.Error())
+	}
+	return OK(MSG{
+		"message_id":    resID,
+		"name":          m.Name,
+		"size":          m.Size,
+		"md5":           m.Md5,
+		"compression":   m.Compression,
+		"stored_size":   m.StoredSize,
+		"chunking":      m.Chunking,
+		"parts":         len(m.Parts),
+		"reused":        stats.Reused,
+		"uploaded_size": stats.UploadedSize,
+		"data_shards":   m.DataShards,
+		"parity_shards": m.ParityShards,
+	})
+}
+
+// CQDownloadChunkedFile 扩展API-下载分块文件, file 为空时下载到缓存目录
+func (bot *CQBot) CQDownloadChunkedFile(ctx context.Context, resID, file string) MSG {
+	if file == "" {
+		hash := md5.Sum([]byte(resID))
+		file = path.Join(global.CachePath, hex.EncodeToString(hash[:])+".cache")
+		defer global.Cache.Acquire(file)()
+	}
+	m, stats, err := bot.DownloadChunkedFile(ctx, resID, file)
+	if err != nil {
+		Logger(ctx).Warnf("下载分块文件 %v 时出现错误: %v", resID, err)
+		if errors.Is(err, global.ErrRemoteMissing) {
+			return Failed(100, "REMOTE_MISSING", err.Error())
+		}
+		return Failed(100, "CHUNKED_DOWNLOAD_FAILED", err.Error())
+	}
+	if stats.Reconstructed > 0 {
+		Logger(ctx).Warnf("分块文件 %v 有 %v 个分块丢失或损坏, 已通过校验分块恢复", resID, stats.Missing+stats.Damaged)
+	}
+	abs, _ := filepath.Abs(file)
+	return OK(MSG{
+		"file":          abs,
+		"name":          m.Name,
+		"size":          m.Size,
+		"parts":         stats.Parts,
+		"missing":       stats.Missing,
+		"damaged":       stats.Damaged,
+		"reconstructed": stats.Reconstructed,
+	})
+}
+
 // CQSendGroupForwardMessage 扩展API-发送合并转发(群)
 //
 // https://docs.go-cqhttp.org/api/#%E5%8F%91%E9%80%81%E5%90%88%E5%B9%B6%E8%BD%AC%E5%8F%91-%E7%BE%A4
-func (bot *CQBot) CQSendGroupForwardMessage(m gjson.Result) MSG {
+func (bot *CQBot) CQSendGroupForwardMessage(ctx context.Context, m gjson.Result) MSG {
 	if m.Type != gjson.JSON {
 		return Failed(100)
 	}
 	var sendNodes []*message.ForwardNode
+	var check videoCheck
 	ts := time.Now().Add(-time.Minute * 5)
 	var convert func(e gjson.Result) []*message.ForwardNode
 	convert = func(e gjson.Result) (nodes []*message.ForwardNode) {
@@ -127,9 +215,10 @@ func (bot *CQBot) CQSendGroupForwardMessage(m gjson.Result) MSG {
 			var newElem []message.IMessageElement
 			for _, elem := range content {
 				if video, ok := elem.(*LocalVideoElement); ok {
-					gm, err := bot.UploadLocalVideo(video)
+					gm, err := bot.resolveVideo(ctx, video, &check)
+					video.Release()
 					if err != nil {
-						log.Warnf("警告：视频上传失败: %v", err)
+						Logger(ctx).Warnf("警告：视频上传失败: %v", err)
 						continue
 					}
 					newElem = append(newElem, gm)
@@ -145,7 +234,8 @@ func (bot *CQBot) CQSendGroupForwardMessage(m gjson.Result) MSG {
 			})
 			return
 		}
-		log.Warnf("警告: 非法 Forward node 将跳过")
+		releaseVideos(content)
+		Logger(ctx).Warnf("警告: 非法 Forward node 将跳过")
 		return
 	}
 	if m.IsArray() {
@@ -158,11 +248,22 @@ func (bot *CQBot) CQSendGroupForwardMessage(m gjson.Result) MSG {
 	if len(sendNodes) > 0 {
 		ret := bot.Client.UploadForwardMessage(&message.ForwardMessage{Nodes: sendNodes})
 		if ret == nil {
-			log.Warnf("合并转发(群)消息发送失败: 账号可能被风控.")
+			Logger(ctx).Warnf("合并转发(群)消息发送失败: 账号可能被风控.")
 			return Failed(100, "SEND_MSG_API_ERROR", "请参考输出")
 		}
+		if err := writeForwardRecord(ret.ResId, len(sendNodes)); err != nil {
+			Logger(ctx).Warnf("保存合并转发记录时出现错误: %v", err)
+		}
+		bot.Publish(&ManifestCreatedEvent{
+			Time:      time.Now().Unix(),
+			SelfID:    bot.Client.Uin,
+			MessageID: ret.ResId,
+			NodeCount: len(sendNodes),
+		})
 		return OK(MSG{
-			"message_id": ret.ResId,
+			"message_id":  ret.ResId,
+			"revalidated": check.Revalidated,
+			"reuploaded":  check.Reuploaded,
 		})
 	}
 	return Failed(100)
@@ -171,7 +272,7 @@ func (bot *CQBot) CQSendGroupForwardMessage(m gjson.Result) MSG {
 // CQGetForwardMessage 获取合并转发消息
 //
 // https://git.io/Jtz1F
-func (bot *CQBot) CQGetForwardMessage(resID string) MSG {
+func (bot *CQBot) CQGetForwardMessage(ctx context.Context, resID string) MSG {
 	m := bot.Client.GetForwardMessage(resID)
 	if m == nil {
 		return Failed(100, "MSG_NOT_FOUND", "消息不存在")
@@ -200,6 +301,47 @@ func (bot *CQBot) CQGetForwardMessage(resID string) MSG {
 	})
 }
 
+// CQGetStatus 获取运行状态
+func (bot *CQBot) CQGetStatus() MSG {
+	return OK(MSG{
+		"app_initialized": true,
+		"app_enabled":     true,
+		"plugins_good":    true,
+		"app_good":        true,
+		"online":          bot.Client.Online,
+		"good":            bot.Client.Online,
+		"stat":            bot.Client.GetStatistics(),
+		"uptime":          int64(time.Since(bot.startTime).Seconds()),
+		"uploads":         bot.Uploads(),
+		"downloads":       bot.Downloads(),
+		"event_queues":    bot.EventQueueStats(),
+		"rate_limiter":    global.GetRateLimiterState(),
+	})
+}
+
+// CQGetVersionInfo 获取版本信息
+func (bot *CQBot) CQGetVersionInfo() MSG {
+	wd, _ := os.Getwd()
+	return OK(MSG{
+		"app_name":                   "go-cqhttp",
+		"app_version":                Version,
+		"app_full_name":              fmt.Sprintf("go-cqhttp-%s_%s_%s-%s", Version, runtime.GOOS, runtime.GOARCH, runtime.Version()),
+		"protocol_version":           "v11",
+		"onebot_version":             "11",
+		"coolq_directory":            wd,
+		"coolq_edition":              "pro",
+		"go-cqhttp":                  true,
+		"plugin_version":             "4.15.0",
+		"plugin_build_number":        99,
+		"plugin_build_configuration": "release",
+		"runtime_version":            runtime.Version(),
+		"runtime_os":                 runtime.GOOS,
+		"runtime_arch":               runtime.GOARCH,
+		"version":                    Version,
+		"protocol":                   client.SystemDeviceInfo.Protocol,
+	})
+}
+
 // OK 生成成功返回值
 func OK(data interface{}) MSG {
 	return MSG{"data": data, "retcode": 0, "status": "ok"}
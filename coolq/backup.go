@@ -0,0 +1,410 @@
+package coolq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sam01101/MiraiGo-qdrive/message"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+// backupSnapshotType 快照根清单的类型标识
+const backupSnapshotType = "gocq_backup_snapshot"
+
+// backupNodeSize 根清单每个文本节点的最大长度
+const backupNodeSize = 8000
+
+// BackupFile 快照中的文件, 内容为一个分块文件
+type BackupFile struct {
+	Path      string `json:"path"` // 去掉卷标与开头 / 的绝对路径
+	Size      int64  `json:"size"`
+	Mode      uint32 `json:"mode"`
+	ModTime   int64  `json:"mod_time"` // UnixNano
+	Md5       string `json:"md5"`
+	MessageID string `json:"message_id"` // 分块文件清单的合并转发ID
+}
+
+// BackupSnapshot 快照根清单, 以 JSON 文本保存在合并转发消息中
+type BackupSnapshot struct {
+	Type    string       `json:"type"`
+	Version int          `json:"version"`
+	ID      string       `json:"id"`
+	Time    int64        `json:"time"`
+	Dirs    []string     `json:"dirs"`
+	Files   []BackupFile `json:"files"`
+}
+
+// BackupIndexEntry 本地快照索引 data/backups/index.json 中的记录
+type BackupIndexEntry struct {
+	ID        string `json:"id"`
+	Time      int64  `json:"time"`
+	MessageID string `json:"message_id"` // 根清单的合并转发ID
+	Files     int    `json:"files"`
+	Size      int64  `json:"size"`
+}
+
+// BackupStats 备份结果
+type BackupStats struct {
+	Files        int   `json:"files"`
+	Unchanged    int   `json:"unchanged"` // 与上一个快照相同而直接复用的文件
+	Parts        int   `json:"parts"`
+	ReusedParts  int   `json:"reused_parts"`
+	UploadedSize int64 `json:"uploaded_size"`
+}
+
+// BackupRetention 快照保留规则, 均为0时保留全部快照
+type BackupRetention struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+}
+
+// snapshotPath 将本地路径转换为快照中保存的路径
+func snapshotPath(file string) string {
+	file = strings.TrimPrefix(file, filepath.VolumeName(file))
+	return strings.TrimLeft(filepath.ToSlash(file), "/")
+}
+
+// backupTree 备份 dirs 下的所有普通文件, prev 中大小、权限与修改时间均未变化的文件将直接复用
+func backupTree(ctx context.Context, store chunkStore, id string, dirs []string, prev *BackupSnapshot, opt ChunkOptions, sender *message.ForwardNode) (*BackupSnapshot, string, *BackupStats, error) {
+	old := map[string]BackupFile{}
+	if prev != nil {
+		for _, f := range prev.Files {
+			old[f.Path] = f
+		}
+	}
+	snap := &BackupSnapshot{Type: backupSnapshotType, Version: 1, ID: id, Time: time.Now().Unix(), Files: []BackupFile{}}
+	stats := &BackupStats{}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		snap.Dirs = append(snap.Dirs, abs)
+		err = filepath.Walk(abs, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			bf := BackupFile{Path: snapshotPath(file), Size: info.Size(), Mode: uint32(info.Mode().Perm()), ModTime: info.ModTime().UnixNano()}
+			stats.Files++
+			if o, ok := old[bf.Path]; ok && o.Size == bf.Size && o.Mode == bf.Mode && o.ModTime == bf.ModTime {
+				stats.Unchanged++
+				snap.Files = append(snap.Files, o)
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			m, resID, us, err := uploadChunked(ctx, store, filepath.Base(file), f, opt, sender)
+			if err != nil {
+				return fmt.Errorf("%v: %w", file, err)
+			}
+			stats.Parts += us.Parts
+			stats.ReusedParts += us.Reused
+			stats.UploadedSize += us.UploadedSize
+			bf.Size, bf.Md5, bf.MessageID = m.Size, m.Md5, resID
+			snap.Files = append(snap.Files, bf)
+			return nil
+		})
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var nodes []*message.ForwardNode
+	for _, text := range splitText(string(b), backupNodeSize) {
+		nodes = append(nodes, &message.ForwardNode{
+			SenderId: sender.SenderId, SenderName: sender.SenderName, Time: sender.Time,
+			Message: []message.IMessageElement{message.NewText(text)},
+		})
+	}
+	resID, err := store.UploadManifest(ctx, nodes)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return snap, resID, stats, nil
+}
+
+// splitText 按不超过 size 字节切分 s, 不会切断 UTF-8 字符
+func splitText(s string, size int) []string {
+	var r []string
+	for len(s) > size {
+		n := size
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		r = append(r, s[:n])
+		s = s[n:]
+	}
+	return append(r, s)
+}
+
+// getSnapshot 从合并转发消息中读取快照根清单
+func getSnapshot(ctx context.Context, store chunkStore, resID string) (*BackupSnapshot, error) {
+	fm, err := store.GetManifest(ctx, resID)
+	if err != nil {
+		return nil, err
+	}
+	return parseSnapshot(fm)
+}
+
+// parseSnapshot 拼接合并转发消息中的文本节点并解析快照根清单
+func parseSnapshot(fm *message.ForwardMessage) (*BackupSnapshot, error) {
+	var sb strings.Builder
+	for _, n := range fm.Nodes {
+		for _, e := range n.Message {
+			if t, ok := e.(*message.TextElement); ok {
+				sb.WriteString(t.Content)
+			}
+		}
+	}
+	snap := &BackupSnapshot{}
+	if err := json.Unmarshal([]byte(sb.String()), snap); err != nil || snap.Type != backupSnapshotType {
+		return nil, errors.New("snapshot manifest not found")
+	}
+	return snap, nil
+}
+
+// restoreSnapshot 将快照中的文件恢复到 dest 下, 文件路径与备份时的绝对路径一致
+func restoreSnapshot(ctx context.Context, store chunkStore, snap *BackupSnapshot, dest string) error {
+	// 快照可能来自任意合并转发消息, 恢复前检查所有路径, 避免写入 dest 之外
+	files := make([]string, len(snap.Files))
+	for i, f := range snap.Files {
+		file, err := restorePath(dest, f.Path)
+		if err != nil {
+			return err
+		}
+		files[i] = file
+	}
+	for i, f := range snap.Files {
+		file := files[i]
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		tmp := file + ".downloading"
+		w, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		_, _, err = downloadChunked(ctx, store, f.MessageID, w)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp, file)
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("%v: %w", f.Path, err)
+		}
+		mt := time.Unix(0, f.ModTime)
+		_ = os.Chmod(file, os.FileMode(f.Mode)&os.ModePerm)
+		_ = os.Chtimes(file, mt, mt)
+	}
+	return nil
+}
+
+var errUnsafeSnapshotPath = errors.New("unsafe path in snapshot")
+
+// restorePath 返回快照路径 p 恢复到 dest 下的位置, 绝对路径或包含 .. 的路径将被拒绝
+func restorePath(dest, p string) (string, error) {
+	if p == "" || strings.HasPrefix(p, "/") || filepath.IsAbs(p) || filepath.VolumeName(p) != "" {
+		return "", fmt.Errorf("%q: %w", p, errUnsafeSnapshotPath)
+	}
+	for _, e := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if e == ".." {
+			return "", fmt.Errorf("%q: %w", p, errUnsafeSnapshotPath)
+		}
+	}
+	dest = filepath.Clean(dest)
+	file := filepath.Clean(filepath.Join(dest, filepath.FromSlash(p)))
+	if rel, err := filepath.Rel(dest, file); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q: %w", p, errUnsafeSnapshotPath)
+	}
+	return file, nil
+}
+
+// pruneSnapshots 按保留规则划分快照, 每条规则在每个时间段内保留最新的一个快照
+func pruneSnapshots(entries []BackupIndexEntry, p BackupRetention) (keep, remove []BackupIndexEntry) {
+	sorted := append([]BackupIndexEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time > sorted[j].Time })
+	if p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0 && p.KeepMonthly <= 0 {
+		return sorted, nil
+	}
+	kept := make([]bool, len(sorted))
+	for i := 0; i < p.KeepLast && i < len(sorted); i++ {
+		kept[i] = true
+	}
+	rule := func(n int, bucket func(time.Time) string) {
+		last := ""
+		for i, e := range sorted {
+			if n <= 0 {
+				return
+			}
+			if b := bucket(time.Unix(e.Time, 0)); b != last {
+				kept[i] = true
+				last = b
+				n--
+			}
+		}
+	}
+	rule(p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+	rule(p.KeepWeekly, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", y, w)
+	})
+	rule(p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") })
+	for i, e := range sorted {
+		if kept[i] {
+			keep = append(keep, e)
+		} else {
+			remove = append(remove, e)
+		}
+	}
+	return
+}
+
+func backupIndexFile() string {
+	return path.Join(global.BackupPath, "index.json")
+}
+
+// snapshotCacheFile 本地保存的快照根清单, 用于下一次备份时比较文件是否变化
+func snapshotCacheFile(id string) string {
+	return path.Join(global.BackupPath, id+".json")
+}
+
+// ReadBackupIndex 读取本地快照索引, 按时间从旧到新排列
+func ReadBackupIndex() ([]BackupIndexEntry, error) {
+	b, err := ioutil.ReadFile(backupIndexFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []BackupIndexEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+	return entries, nil
+}
+
+func writeBackupIndex(entries []BackupIndexEntry) error {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(global.BackupPath, 0755); err != nil {
+		return err
+	}
+	tmp := backupIndexFile() + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, backupIndexFile())
+}
+
+// findSnapshot 按ID查找快照, latest 为最新的快照, 找不到时视为根清单的合并转发ID
+func findSnapshot(entries []BackupIndexEntry, id string) BackupIndexEntry {
+	if id == "latest" && len(entries) > 0 {
+		return entries[len(entries)-1]
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e
+		}
+	}
+	return BackupIndexEntry{MessageID: id}
+}
+
+// Backup 备份 dirs 并记录到本地快照索引, 完成后按 retention 清理快照索引
+func (bot *CQBot) Backup(ctx context.Context, dirs []string, retention BackupRetention) (*BackupIndexEntry, *BackupStats, error) {
+	if len(dirs) == 0 {
+		return nil, nil, errors.New("no backup dirs configured")
+	}
+	entries, err := ReadBackupIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	store := &botChunkStore{bot: bot}
+	var prev *BackupSnapshot
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		prev = &BackupSnapshot{}
+		if b, err := ioutil.ReadFile(snapshotCacheFile(last.ID)); err != nil || json.Unmarshal(b, prev) != nil {
+			if prev, err = getSnapshot(ctx, store, last.MessageID); err != nil {
+				log.Warnf("读取上一个快照 %v 失败, 将上传全部文件: %v", last.ID, err)
+			}
+		}
+	}
+	now := time.Now()
+	id := now.Format("20060102-150405")
+	for i := 1; findSnapshot(entries, id).ID != ""; i++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), i)
+	}
+	opt := bot.chunkOpts
+	opt.Chunking = ChunkingCDC
+	sender := &message.ForwardNode{SenderId: bot.Client.Uin, SenderName: bot.Client.Nickname, Time: int32(now.Unix())}
+	snap, resID, stats, err := backupTree(ctx, store, id, dirs, prev, opt, sender)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := BackupIndexEntry{ID: id, Time: snap.Time, MessageID: resID, Files: len(snap.Files)}
+	for _, f := range snap.Files {
+		entry.Size += f.Size
+	}
+	if b, err := json.Marshal(snap); err == nil {
+		_ = os.MkdirAll(global.BackupPath, 0755)
+		if err = ioutil.WriteFile(snapshotCacheFile(id), b, 0644); err != nil {
+			log.Warnf("保存快照 %v 的本地清单时出现错误: %v", id, err)
+		}
+	}
+	keep, remove := pruneSnapshots(append(entries, entry), retention)
+	if err = writeBackupIndex(keep); err != nil {
+		return nil, nil, err
+	}
+	for _, e := range remove {
+		_ = os.Remove(snapshotCacheFile(e.ID))
+		log.Infof("已按保留规则移除快照 %v", e.ID)
+	}
+	return &entry, stats, nil
+}
+
+// Restore 将快照恢复到 dest, snapshot 为快照ID、latest 或根清单的合并转发ID
+func (bot *CQBot) Restore(ctx context.Context, snapshot, dest string) (*BackupSnapshot, error) {
+	entries, err := ReadBackupIndex()
+	if err != nil {
+		return nil, err
+	}
+	store := &botChunkStore{bot: bot}
+	snap, err := getSnapshot(ctx, store, findSnapshot(entries, snapshot).MessageID)
+	if err != nil {
+		return nil, err
+	}
+	return snap, restoreSnapshot(ctx, store, snap, dest)
+}
@@ -0,0 +1,114 @@
+package coolq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam01101/MiraiGo-qdrive/message"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	_ = os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(src, "sub", "b.txt"), bytes.Repeat([]byte("b"), 5000), 0600)
+	store := newFakeChunkStore()
+	sender := &message.ForwardNode{SenderId: 1, SenderName: "test"}
+	opt := ChunkOptions{PartSize: 1024, Chunking: ChunkingCDC}
+	snap, _, stats, err := backupTree(context.Background(), store, "1", []string{src}, nil, opt, sender)
+	if err != nil || stats.Files != 2 || stats.Unchanged != 0 {
+		t.Fatalf("unexpected first backup %+v: %v", stats, err)
+	}
+
+	// 未修改的文件直接复用
+	_ = ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hello world"), 0644)
+	snap, resID, stats, err := backupTree(context.Background(), store, "2", []string{src}, snap, opt, sender)
+	if err != nil || stats.Files != 2 || stats.Unchanged != 1 {
+		t.Fatalf("unexpected second backup %+v: %v", stats, err)
+	}
+
+	dest, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	if snap, err = getSnapshot(context.Background(), store, resID); err != nil || snap.ID != "2" {
+		t.Fatalf("get snapshot: %v", err)
+	}
+	if err = restoreSnapshot(context.Background(), store, snap, dest); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dest, snapshotPath(filepath.Join(src, "a.txt"))))
+	if err != nil || string(b) != "hello world" {
+		t.Fatalf("restored a.txt = %q, %v", b, err)
+	}
+	info, err := os.Stat(filepath.Join(dest, snapshotPath(filepath.Join(src, "sub", "b.txt"))))
+	if err != nil || info.Size() != 5000 {
+		t.Fatalf("restored b.txt: %v", err)
+	}
+
+	// 快照中记录的 setuid 等特殊权限位不会被恢复
+	setuid := *snap
+	setuid.Files = []BackupFile{snap.Files[0]}
+	setuid.Files[0].Mode = uint32(os.ModeSetuid | os.ModeSetgid | 0755)
+	if err = restoreSnapshot(context.Background(), store, &setuid, dest); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(filepath.Join(dest, snapshotPath(setuid.Files[0].Path)))
+	if err != nil || info.Mode() != 0755 {
+		t.Fatalf("restored mode = %v, %v", info.Mode(), err)
+	}
+
+	// 恶意快照中的路径不能写入恢复目录之外
+	target := filepath.Join(dest, "target")
+	for _, p := range []string{"../evil.txt", "a/../../evil.txt", "/tmp/evil.txt", "a/..", "..\\evil.txt", ""} {
+		evil := *snap
+		evil.Files = append([]BackupFile{snap.Files[0]}, BackupFile{Path: p, MessageID: snap.Files[0].MessageID})
+		if err = restoreSnapshot(context.Background(), store, &evil, target); !errors.Is(err, errUnsafeSnapshotPath) {
+			t.Fatalf("restore %q: got %v, want %v", p, err, errUnsafeSnapshotPath)
+		}
+		if global.PathExists(filepath.Join(dest, "evil.txt")) || global.PathExists(target) {
+			t.Fatalf("restore %q wrote files", p)
+		}
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	now := time.Date(2021, 3, 31, 12, 0, 0, 0, time.Local)
+	var entries []BackupIndexEntry
+	// 每天两个快照, 共90天
+	for i := 0; i < 180; i++ {
+		ts := now.Add(-time.Duration(i) * 12 * time.Hour)
+		entries = append(entries, BackupIndexEntry{ID: strconv.Itoa(i), Time: ts.Unix()})
+	}
+	keep, remove := pruneSnapshots(entries, BackupRetention{KeepLast: 3, KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 3})
+	if len(keep)+len(remove) != len(entries) {
+		t.Fatal("entries lost")
+	}
+	var ids []string
+	for _, e := range keep {
+		ids = append(ids, e.ID)
+	}
+	// 最近3个, 最近7天, 最近4周 (2021-03-31 为周三) 与最近3个月各自最新的快照
+	if got := strings.Join(ids, ","); got != "0,1,2,4,6,8,10,12,20,34,62,118" {
+		t.Fatalf("unexpected kept snapshots %v", got)
+	}
+	keep, remove = pruneSnapshots(entries, BackupRetention{})
+	if len(keep) != len(entries) || len(remove) != 0 {
+		t.Fatal("empty retention should keep everything")
+	}
+}
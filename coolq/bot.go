@@ -1,11 +1,12 @@
 package coolq
 
 import (
+	"context"
 	"encoding/hex"
 	"io"
 	"os"
 	"path"
-	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/sam01101/MiraiGo-qdrive/client"
@@ -22,7 +23,27 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 type CQBot struct {
 	Client *client.QQClient
 
-	events []func(MSG)
+	events        []*eventHandler
+	eventsMutex   sync.RWMutex
+	nextEventID   uint64
+	queueSize     int
+	queueOverflow string
+
+	startTime time.Time
+	transfer  transferStats
+
+	videoProbe bool
+	chunkOpts  ChunkOptions
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type eventHandler struct {
+	id     uint64
+	name   string
+	topics []Topic
+	queue  *eventQueue
 }
 
 // MSG 消息Map
@@ -31,7 +52,19 @@ type MSG map[string]interface{}
 // NewQQBot 初始化一个QQBot实例
 func NewQQBot(cli *client.QQClient, conf *global.JSONConfig) *CQBot {
 	bot := &CQBot{
-		Client: cli,
+		Client:    cli,
+		startTime: time.Now(),
+		closed:    make(chan struct{}),
+	}
+	if conf.EventQueue != nil {
+		bot.queueSize = conf.EventQueue.Size
+		bot.queueOverflow = conf.EventQueue.Overflow
+	}
+	if c := conf.ChunkedFile; c != nil {
+		bot.chunkOpts = ChunkOptions{PartSize: c.PartSize * 1024 * 1024, DataShards: c.DataShards, ParityShards: c.ParityShards, Compression: c.Compression, Chunking: c.Chunking}
+	}
+	if conf.VideoRecord != nil {
+		bot.videoProbe = conf.VideoRecord.Probe
 	}
 	go func() {
 		i := conf.HeartbeatInterval
@@ -42,23 +75,164 @@ func NewQQBot(cli *client.QQClient, conf *global.JSONConfig) *CQBot {
 		if i == 0 {
 			i = 5
 		}
+		t := time.NewTicker(time.Second * i)
+		defer t.Stop()
 		for {
-			time.Sleep(time.Second * i)
-			bot.dispatchEventMessage(MSG{
-				"time":            time.Now().Unix(),
-				"self_id":         bot.Client.Uin,
-				"post_type":       "meta_event",
-				"meta_event_type": "heartbeat",
-				"interval":        1000 * i,
-			})
+			select {
+			case <-bot.closed:
+				return
+			case <-t.C:
+			}
+			bot.Publish(bot.Heartbeat(time.Second * i))
 		}
 	}()
 	return bot
 }
 
-// OnEventPush 注册事件上报函数
-func (bot *CQBot) OnEventPush(f func(m MSG)) {
-	bot.events = append(bot.events, f)
+// Close 停止心跳、健康检查等后台任务并关闭所有订阅, 热重启时旧的实例需要调用
+func (bot *CQBot) Close() {
+	bot.closeOnce.Do(func() {
+		if bot.closed != nil {
+			close(bot.closed)
+		}
+		bot.eventsMutex.Lock()
+		for _, h := range bot.events {
+			h.queue.close()
+		}
+		bot.events = nil
+		bot.eventsMutex.Unlock()
+	})
+}
+
+// OnEventPush 注册事件上报函数, 返回的函数用于取消注册
+func (bot *CQBot) OnEventPush(f func(m MSG)) (unregister func()) {
+	return bot.Subscribe("", f).Unsubscribe
+}
+
+// Subscribe 订阅事件, topics 为空时接收全部事件, 否则仅接收匹配任一 Topic 的事件
+//
+// 每个订阅者拥有独立的有界队列, 事件按上报顺序依次交给 f 处理, name 用于统计信息
+func (bot *CQBot) Subscribe(name string, f func(m MSG), topics ...Topic) *Subscription {
+	h := &eventHandler{name: name, topics: topics, queue: newEventQueue(bot.queueSize, bot.queueOverflow)}
+	bot.eventsMutex.Lock()
+	bot.nextEventID++
+	h.id = bot.nextEventID
+	bot.events = append(bot.events, h)
+	bot.eventsMutex.Unlock()
+	go h.queue.run(f)
+	return &Subscription{bot: bot, id: h.id}
+}
+
+// SubscribeEvent 以 Event 的形式订阅事件, 无法转换为 Event 的事件将被忽略
+func (bot *CQBot) SubscribeEvent(name string, f func(e Event), topics ...Topic) *Subscription {
+	return bot.Subscribe(name, func(m MSG) {
+		if e, ok := ParseEvent(m); ok {
+			f(e)
+		}
+	}, topics...)
+}
+
+// Publish 上报事件
+func (bot *CQBot) Publish(e Event) {
+	bot.dispatchEventMessage(e.ToMSG())
+}
+
+func (bot *CQBot) unsubscribe(id uint64) {
+	bot.eventsMutex.Lock()
+	defer bot.eventsMutex.Unlock()
+	for i, h := range bot.events {
+		if h.id == id {
+			bot.events = append(bot.events[:i:i], bot.events[i+1:]...)
+			h.queue.close()
+			return
+		}
+	}
+}
+
+// FlushEvents 等待所有订阅者处理完已上报的事件, 直到 ctx 结束
+func (bot *CQBot) FlushEvents(ctx context.Context) error {
+	for {
+		bot.eventsMutex.RLock()
+		events := bot.events
+		bot.eventsMutex.RUnlock()
+		idle := true
+		for _, h := range events {
+			if !h.queue.idle() {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+}
+
+// EventQueueStats 返回所有订阅者的事件队列统计
+func (bot *CQBot) EventQueueStats() []EventQueueStats {
+	bot.eventsMutex.RLock()
+	events := bot.events
+	bot.eventsMutex.RUnlock()
+	r := make([]EventQueueStats, 0, len(events))
+	for _, h := range events {
+		st := h.queue.stats()
+		st.ID, st.Name = h.id, h.name
+		r = append(r, st)
+	}
+	return r
+}
+
+// Subscription 事件订阅句柄
+type Subscription struct {
+	bot  *CQBot
+	id   uint64
+	once sync.Once
+}
+
+// Unsubscribe 取消订阅, 可重复调用
+func (s *Subscription) Unsubscribe() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.bot.unsubscribe(s.id)
+	})
+}
+
+// Topic 事件过滤条件, 为空的字段匹配任意值
+type Topic struct {
+	PostType string // post_type, 如 notice, meta_event
+	Type     string // 二级类型, 即 notice_type, meta_event_type 等字段的值
+}
+
+// Match 判断事件是否匹配
+func (t Topic) Match(m MSG) bool {
+	postType, _ := m["post_type"].(string)
+	if t.PostType != "" && t.PostType != postType {
+		return false
+	}
+	if t.Type != "" {
+		typ, _ := m[postType+"_type"].(string)
+		return t.Type == typ
+	}
+	return true
+}
+
+func (h *eventHandler) match(m MSG) bool {
+	if len(h.topics) == 0 {
+		return true
+	}
+	for _, t := range h.topics {
+		if t.Match(m) {
+			return true
+		}
+	}
+	return false
 }
 
 // UploadLocalVideo 上传本地短视频至群聊
@@ -71,6 +245,8 @@ func (bot *CQBot) UploadLocalVideo(v *LocalVideoElement) (*message.ShortVideoEle
 		defer video.Close()
 		hash, _ := utils.ComputeMd5AndLength(io.MultiReader(video, v.thumb))
 		cacheFile := path.Join(global.CachePath, hex.EncodeToString(hash[:])+".cache")
+		defer global.Cache.Acquire(v.File)()
+		defer global.Cache.Acquire(cacheFile)()
 		_, _ = video.Seek(0, io.SeekStart)
 		_, _ = v.thumb.Seek(0, io.SeekStart)
 		return bot.Client.UploadGroupShortVideo(0, video, v.thumb, cacheFile)
@@ -79,20 +255,16 @@ func (bot *CQBot) UploadLocalVideo(v *LocalVideoElement) (*message.ShortVideoEle
 }
 
 func (bot *CQBot) dispatchEventMessage(m MSG) {
-	for _, f := range bot.events {
-		go func(fn func(MSG)) {
-			defer func() {
-				if pan := recover(); pan != nil {
-					log.Warnf("处理事件 %v 时出现错误: %v \n%s", m, pan, debug.Stack())
-				}
-			}()
-			start := time.Now()
-			fn(m)
-			end := time.Now()
-			if end.Sub(start) > time.Second*5 {
-				log.Debugf("警告: 事件处理耗时超过 5 秒 (%v), 请检查应用是否有堵塞.", end.Sub(start))
-			}
-		}(f)
+	bot.eventsMutex.RLock()
+	events := bot.events
+	bot.eventsMutex.RUnlock()
+	for _, h := range events {
+		if !h.match(m) {
+			continue
+		}
+		if !h.queue.push(m) {
+			log.Debugf("订阅者 %v 的事件队列已满或已关闭, 已丢弃事件.", h.name)
+		}
 	}
 }
 
@@ -0,0 +1,166 @@
+package coolq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestSubscribe(t *testing.T) {
+	bot := &CQBot{}
+	all := make(chan MSG, 4)
+	notice := make(chan Event, 4)
+	subAll := bot.Subscribe("all", func(m MSG) { all <- m })
+	subNotice := bot.SubscribeEvent("notice", func(e Event) { notice <- e }, Topic{PostType: "notice", Type: "upload_complete"})
+
+	bot.Publish(&HeartbeatEvent{Time: 1, SelfID: 2, Interval: 5000})
+	bot.Publish(&UploadCompleteEvent{Time: 1, SelfID: 2, FileName: "a.video", Size: 10})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-all:
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+	select {
+	case e := <-notice:
+		u, ok := e.(*UploadCompleteEvent)
+		if !ok || u.FileName != "a.video" || u.Size != 10 {
+			t.Fatalf("unexpected event: %#v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("filtered event not delivered")
+	}
+	select {
+	case e := <-notice:
+		t.Fatalf("unexpected event: %#v", e)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	subAll.Unsubscribe()
+	subAll.Unsubscribe()
+	subNotice.Unsubscribe()
+	if len(bot.events) != 0 {
+		t.Fatalf("handlers not removed: %d", len(bot.events))
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	decoded := (&ManifestCreatedEvent{Time: 1, SelfID: 2, MessageID: "res", NodeCount: 3}).ToMSG()
+	decoded["node_count"] = float64(3) // JSON 解码得到的数字
+	e, ok := ParseEvent(decoded)
+	if !ok {
+		t.Fatal("parse failed")
+	}
+	if mc := e.(*ManifestCreatedEvent); mc.MessageID != "res" || mc.NodeCount != 3 || mc.SelfID != 2 {
+		t.Fatalf("unexpected event: %#v", mc)
+	}
+	if _, ok := ParseEvent(MSG{"post_type": "message"}); ok {
+		t.Fatal("unexpected parse success")
+	}
+}
+
+func TestBotClose(t *testing.T) {
+	bot := NewQQBot(nil, &global.JSONConfig{})
+	got := make(chan MSG, 1)
+	bot.Subscribe("all", func(m MSG) { got <- m })
+	bot.Close()
+	bot.Close()
+	select {
+	case <-bot.closed:
+	default:
+		t.Fatal("closed channel not closed")
+	}
+	bot.Publish(&HeartbeatEvent{Time: 1, SelfID: 2, Interval: 5000})
+	select {
+	case m := <-got:
+		t.Fatalf("event delivered after Close: %v", m)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestEventQueueOverflow(t *testing.T) {
+	q := newEventQueue(2, OverflowDropOldest)
+	for i := 0; i < 3; i++ {
+		q.push(MSG{"i": i})
+	}
+	if m, _ := q.pop(); m["i"] != 1 {
+		t.Fatalf("drop_oldest kept %v", m["i"])
+	}
+	q = newEventQueue(2, OverflowDropNewest)
+	for i := 0; i < 3; i++ {
+		q.push(MSG{"i": i})
+	}
+	if st := q.stats(); st.Dropped != 1 || st.Depth != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if m, _ := q.pop(); m["i"] != 0 {
+		t.Fatalf("drop_newest kept %v", m["i"])
+	}
+	q = newEventQueue(1, OverflowBlock)
+	q.push(MSG{"i": 0})
+	done := make(chan struct{})
+	go func() {
+		q.push(MSG{"i": 1})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("push should block while queue is full")
+	case <-time.After(time.Millisecond * 50):
+	}
+	q.pop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("push not released")
+	}
+}
+
+func TestMetaEvents(t *testing.T) {
+	bot := &CQBot{}
+	sub := bot.Subscribe("test", func(MSG) {})
+	defer sub.Unsubscribe()
+	hb, ok := ParseEvent(bot.Heartbeat(time.Second * 5).ToMSG())
+	if !ok {
+		t.Fatal("parse heartbeat failed")
+	}
+	if h := hb.(*HeartbeatEvent); h.Interval != 5000 || h.Status == nil || len(h.Status.EventQueues) != 1 {
+		t.Fatalf("unexpected heartbeat: %#v", h)
+	}
+	m := bot.Lifecycle(LifecycleConnect).ToMSG()
+	if m["meta_event_type"] != "lifecycle" || m["sub_type"] != LifecycleConnect {
+		t.Fatalf("unexpected lifecycle: %v", m)
+	}
+}
+
+func TestTransferStats(t *testing.T) {
+	bot := &CQBot{}
+	bot.transfer.upload(100, time.Now(), nil)
+	bot.transfer.upload(0, time.Now(), errors.New("failed"))
+	bot.transfer.download(20, time.Now(), nil)
+	if u := bot.Uploads(); u.Success != 1 || u.Failed != 1 || u.Bytes != 100 {
+		t.Fatalf("unexpected uploads: %+v", u)
+	}
+	if d := bot.Downloads(); d.Success != 1 || d.Failed != 0 || d.Bytes != 20 {
+		t.Fatalf("unexpected downloads: %+v", d)
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	ctx := WithLogFields(context.Background(), log.Fields{"request_id": "1", "action": "a"})
+	ctx = WithLogFields(ctx, log.Fields{"action": "b"})
+	e := Logger(ctx)
+	if e.Data["request_id"] != "1" || e.Data["action"] != "b" {
+		t.Fatalf("unexpected fields: %v", e.Data)
+	}
+	if len(Logger(context.Background()).Data) != 0 {
+		t.Fatal("unexpected fields on empty context")
+	}
+}
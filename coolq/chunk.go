@@ -0,0 +1,585 @@
+package coolq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/sam01101/MiraiGo-qdrive/message"
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+// chunkManifestType 分块文件清单的类型标识
+const chunkManifestType = "gocq_chunked_file"
+
+// 分块方式
+const (
+	ChunkingFixed = "fixed" // 固定大小分块
+	ChunkingCDC   = "cdc"   // 基于内容分块, 分块大小介于 part_size 的 1/4 与 2 倍之间
+)
+
+// ChunkManifest 分块文件清单, 以文本形式保存在合并转发消息的第一个节点, 其余节点各包含一个分块
+//
+// 分块按 DataShards 个一组划分为条带, 每个条带附加 ParityShards 个 Reed-Solomon 校验分块,
+// 条带内的数据分块不足 DataShards 个或长度不足时按0填充计算校验
+type ChunkManifest struct {
+	Type         string      `json:"type"`
+	Version      int         `json:"version"`
+	Name         string      `json:"name"`
+	Size         int64       `json:"size"` // 原始文件大小
+	Md5          string      `json:"md5"`  // 原始文件MD5
+	Compression  string      `json:"compression,omitempty"`
+	StoredSize   int64       `json:"stored_size"` // 压缩后实际上传的数据大小, 不含校验分块
+	PartSize     int64       `json:"part_size"`
+	Chunking     string      `json:"chunking,omitempty"`
+	DataShards   int         `json:"data_shards"`
+	ParityShards int         `json:"parity_shards"`
+	Parts        []ChunkPart `json:"parts"`
+}
+
+// ChunkPart 分块信息
+type ChunkPart struct {
+	Stripe int    `json:"stripe"`
+	Shard  int    `json:"shard"` // 小于 data_shards 为数据分块, 否则为校验分块
+	Size   int64  `json:"size"`
+	Md5    string `json:"md5"`
+}
+
+// ChunkOptions 分块上传参数
+type ChunkOptions struct {
+	PartSize     int64
+	DataShards   int
+	ParityShards int
+	Compression  string // none, gzip, zstd 或 auto
+	Chunking     string // fixed 或 cdc
+}
+
+// ChunkUploadStats 分块上传结果
+type ChunkUploadStats struct {
+	Parts        int   `json:"parts"`
+	Reused       int   `json:"reused"`        // 与已上传的分块相同而直接复用的分块
+	UploadedSize int64 `json:"uploaded_size"` // 实际上传的数据大小, 含校验分块
+}
+
+// ChunkDownloadStats 分块下载结果
+type ChunkDownloadStats struct {
+	Parts         int `json:"parts"`
+	Missing       int `json:"missing"`       // 服务器上已不存在的分块
+	Damaged       int `json:"damaged"`       // 下载失败或校验不一致的分块
+	Reconstructed int `json:"reconstructed"` // 通过校验分块恢复的数据分块
+}
+
+// errPartMissing 分块在服务器上已不存在
+var errPartMissing = errors.New("part missing")
+
+// chunkStore 分块上传/下载所需的存储操作
+type chunkStore interface {
+	// FindPart 在本地索引中查找已上传的相同分块, 不存在或已失效时返回 nil
+	FindPart(ctx context.Context, md5 string, size int64) *message.ShortVideoElement
+	UploadPart(ctx context.Context, data []byte) (*message.ShortVideoElement, error)
+	DownloadPart(ctx context.Context, v *message.ShortVideoElement) ([]byte, error)
+	UploadManifest(ctx context.Context, nodes []*message.ForwardNode) (string, error)
+	GetManifest(ctx context.Context, resID string) (*message.ForwardMessage, error)
+}
+
+func (o *ChunkOptions) normalize() {
+	if o.PartSize <= 0 {
+		o.PartSize = 32 * 1024 * 1024
+	}
+	if o.DataShards <= 0 {
+		o.DataShards = 1
+	}
+	if o.ParityShards < 0 {
+		o.ParityShards = 0
+	}
+	if o.Chunking == "" {
+		o.Chunking = ChunkingFixed
+	}
+}
+
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// uploadChunked 将 r 分块上传并生成清单, 返回清单与合并转发 ResID
+//
+// 与本地索引中已上传分块相同的分块将直接复用, 不会重复上传
+func uploadChunked(ctx context.Context, store chunkStore, name string, r io.Reader, opt ChunkOptions, sender *message.ForwardNode) (*ChunkManifest, string, *ChunkUploadStats, error) {
+	opt.normalize()
+	if opt.Chunking != ChunkingFixed && opt.Chunking != ChunkingCDC {
+		return nil, "", nil, fmt.Errorf("unknown chunking %v", opt.Chunking)
+	}
+	var rs *global.ReedSolomon
+	if opt.ParityShards > 0 {
+		var err error
+		if rs, err = global.NewReedSolomon(opt.DataShards, opt.ParityShards); err != nil {
+			return nil, "", nil, err
+		}
+	}
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	alg, err := global.ChooseCompression(opt.Compression, head, name)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("compression %v: %w", opt.Compression, err)
+	}
+	m := &ChunkManifest{
+		Type: chunkManifestType, Version: 1, Name: name, Compression: alg, PartSize: opt.PartSize,
+		DataShards: opt.DataShards, ParityShards: opt.ParityShards, Parts: []ChunkPart{},
+	}
+	if opt.Chunking == ChunkingCDC {
+		m.Chunking = ChunkingCDC
+	}
+	stats := &ChunkUploadStats{}
+	var (
+		fileHash = md5.New()
+		size     = &countWriter{}
+		src      = io.TeeReader(br, io.MultiWriter(fileHash, size))
+		stored   = src
+		nodes    = []*message.ForwardNode{nil}
+		eof      bool
+	)
+	if alg != "" {
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			zw, err := global.NewCompressWriter(alg, pw)
+			if err == nil {
+				_, err = io.Copy(zw, src)
+				if cerr := zw.Close(); err == nil {
+					err = cerr
+				}
+			}
+			_ = pw.CloseWithError(err)
+		}()
+		stored = pr
+	}
+	next := fixedChunks(stored, opt.PartSize)
+	if opt.Chunking == ChunkingCDC {
+		next = global.NewChunker(stored, int(opt.PartSize/4), int(opt.PartSize), int(opt.PartSize*2)).Next
+	}
+	for stripe := 0; !eof; stripe++ {
+		var data [][]byte
+		data, eof, err = readStripe(next, opt.DataShards)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if len(data) == 0 {
+			break
+		}
+		shards := data
+		if rs != nil {
+			shards = paddedShards(data, opt.DataShards, opt.ParityShards, stripeShardSize(data))
+			if err = rs.Encode(shards); err != nil {
+				return nil, "", nil, err
+			}
+		}
+		for i, b := range shards {
+			if i < opt.DataShards {
+				if i >= len(data) {
+					continue
+				}
+				b = data[i]
+			}
+			sum := md5Hex(b)
+			v := store.FindPart(ctx, sum, int64(len(b)))
+			if v != nil {
+				stats.Reused++
+			} else {
+				if v, err = store.UploadPart(ctx, b); err != nil {
+					return nil, "", nil, fmt.Errorf("upload part %d of stripe %d: %w", i, stripe, err)
+				}
+				stats.UploadedSize += int64(len(b))
+			}
+			if i < opt.DataShards {
+				m.StoredSize += int64(len(b))
+			}
+			m.Parts = append(m.Parts, ChunkPart{Stripe: stripe, Shard: i, Size: int64(len(b)), Md5: sum})
+			nodes = append(nodes, &message.ForwardNode{
+				SenderId: sender.SenderId, SenderName: sender.SenderName, Time: sender.Time,
+				Message: []message.IMessageElement{v},
+			})
+		}
+	}
+	m.Size = size.n
+	m.Md5 = hex.EncodeToString(fileHash.Sum(nil))
+	stats.Parts = len(m.Parts)
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	nodes[0] = &message.ForwardNode{
+		SenderId: sender.SenderId, SenderName: sender.SenderName, Time: sender.Time,
+		Message: []message.IMessageElement{message.NewText(string(b))},
+	}
+	resID, err := store.UploadManifest(ctx, nodes)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return m, resID, stats, nil
+}
+
+// countWriter 统计写入的字节数
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// fixedChunks 按固定大小 size 切分 r, 数据读取完毕时返回 io.EOF
+func fixedChunks(r io.Reader, size int64) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		b := make([]byte, size)
+		n, err := io.ReadFull(r, b)
+		if n > 0 {
+			return b[:n], nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+}
+
+// readStripe 读取最多 k 个分块, 文件结束时返回的分块可能不足并返回 eof
+func readStripe(next func() ([]byte, error), k int) (data [][]byte, eof bool, err error) {
+	for len(data) < k {
+		b, err := next()
+		if err == io.EOF {
+			return data, true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		data = append(data, b)
+	}
+	return data, false, nil
+}
+
+func stripeShardSize(data [][]byte) int {
+	size := 0
+	for _, b := range data {
+		if len(b) > size {
+			size = len(b)
+		}
+	}
+	return size
+}
+
+// paddedShards 生成 k+m 个分片, 数据分片按0填充为长度 size, 不足 k 个时补全0分片, 校验分片为 nil 由 Encode 计算
+func paddedShards(data [][]byte, k, m, size int) [][]byte {
+	shards := make([][]byte, k+m)
+	for i := 0; i < k; i++ {
+		shards[i] = make([]byte, size)
+		if i < len(data) {
+			copy(shards[i], data[i])
+		}
+	}
+	return shards
+}
+
+// parseChunkManifest 从合并转发消息中解析分块清单, 并按 MD5 索引其中的分块
+func parseChunkManifest(fm *message.ForwardMessage) (*ChunkManifest, map[string]*message.ShortVideoElement, error) {
+	if len(fm.Nodes) == 0 {
+		return nil, nil, errors.New("empty forward message")
+	}
+	m := &ChunkManifest{}
+	found := false
+	for _, e := range fm.Nodes[0].Message {
+		if t, ok := e.(*message.TextElement); ok {
+			if err := json.Unmarshal([]byte(t.Content), m); err == nil && m.Type == chunkManifestType {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, nil, errors.New("chunk manifest not found")
+	}
+	if m.DataShards <= 0 || m.ParityShards < 0 {
+		return nil, nil, errors.New("invalid chunk manifest")
+	}
+	parts := map[string]*message.ShortVideoElement{}
+	for _, n := range fm.Nodes[1:] {
+		for _, e := range n.Message {
+			if v, ok := e.(*message.ShortVideoElement); ok {
+				parts[hex.EncodeToString(v.Md5)] = v
+			}
+		}
+	}
+	return m, parts, nil
+}
+
+// downloadChunked 下载分块文件并写入 w, 数据分块丢失或损坏时使用校验分块恢复
+func downloadChunked(ctx context.Context, store chunkStore, resID string, w io.Writer) (*ChunkManifest, *ChunkDownloadStats, error) {
+	fm, err := store.GetManifest(ctx, resID)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, elems, err := parseChunkManifest(fm)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rs *global.ReedSolomon
+	if m.ParityShards > 0 {
+		if rs, err = global.NewReedSolomon(m.DataShards, m.ParityShards); err != nil {
+			return nil, nil, err
+		}
+	}
+	stats := &ChunkDownloadStats{Parts: len(m.Parts)}
+	fetch := func(p *ChunkPart) []byte {
+		v, ok := elems[p.Md5]
+		if !ok {
+			stats.Missing++
+			return nil
+		}
+		b, err := store.DownloadPart(ctx, v)
+		switch {
+		case errors.Is(err, errPartMissing) || errors.Is(err, global.ErrRemoteMissing):
+			stats.Missing++
+			return nil
+		case err != nil || int64(len(b)) != p.Size || md5Hex(b) != p.Md5:
+			stats.Damaged++
+			return nil
+		}
+		return b
+	}
+	var (
+		hash = md5.New()
+		size = &countWriter{}
+		dst  = io.MultiWriter(w, hash, size)
+		out  = dst
+		pw   *io.PipeWriter
+		done chan error
+	)
+	if m.Compression != "" {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		done = make(chan error, 1)
+		go func() {
+			zr, err := global.NewDecompressReader(m.Compression, pr)
+			if err == nil {
+				_, err = io.Copy(dst, zr)
+				_ = zr.Close()
+			}
+			_ = pr.CloseWithError(err)
+			done <- err
+		}()
+		out = pw
+	}
+	for start := 0; start < len(m.Parts) && err == nil; {
+		end := start
+		for end < len(m.Parts) && m.Parts[end].Stripe == m.Parts[start].Stripe {
+			end++
+		}
+		err = downloadStripe(ctx, m, m.Parts[start:end], rs, fetch, stats, out)
+		start = end
+	}
+	if pw != nil {
+		_ = pw.CloseWithError(err)
+		if derr := <-done; err == nil && derr != nil {
+			err = fmt.Errorf("decompress %v: %w", m.Compression, derr)
+		}
+	}
+	if err != nil {
+		return m, stats, err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != m.Md5 || size.n != m.Size {
+		return m, stats, fmt.Errorf("file md5 mismatch: %v != %v", sum, m.Md5)
+	}
+	return m, stats, nil
+}
+
+func downloadStripe(ctx context.Context, m *ChunkManifest, parts []ChunkPart, rs *global.ReedSolomon,
+	fetch func(*ChunkPart) []byte, stats *ChunkDownloadStats, out io.Writer) error {
+	var (
+		data, parity []*ChunkPart
+		shardSize    int64
+	)
+	for i := range parts {
+		p := &parts[i]
+		if p.Shard < m.DataShards {
+			data = append(data, p)
+		} else {
+			parity = append(parity, p)
+		}
+		if p.Size > shardSize {
+			shardSize = p.Size
+		}
+	}
+	shards := make([][]byte, m.DataShards+m.ParityShards)
+	lost, missing := 0, stats.Missing
+	for _, p := range data {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if shards[p.Shard] = fetch(p); shards[p.Shard] == nil {
+			lost++
+		}
+	}
+	if lost > 0 {
+		// 因分块在服务器上已不存在而无法恢复时返回 global.ErrRemoteMissing
+		fail := func(err error) error {
+			if stats.Missing > missing {
+				return fmt.Errorf("stripe %d: %v: %w", parts[0].Stripe, err, global.ErrRemoteMissing)
+			}
+			return fmt.Errorf("stripe %d: %w", parts[0].Stripe, err)
+		}
+		if rs == nil {
+			return fail(fmt.Errorf("%d parts lost and no parity available", lost))
+		}
+		// 条带中不存在的数据分块视为全0
+		for i := len(data); i < m.DataShards; i++ {
+			shards[i] = make([]byte, shardSize)
+		}
+		valid := m.DataShards - lost
+		for _, p := range parity {
+			if valid >= m.DataShards {
+				break
+			}
+			if shards[p.Shard] = fetch(p); shards[p.Shard] != nil {
+				valid++
+			}
+		}
+		for i := 0; i < m.DataShards; i++ {
+			if b := shards[i]; b != nil && int64(len(b)) < shardSize {
+				shards[i] = append(b, make([]byte, shardSize-int64(len(b)))...)
+			}
+		}
+		// 未使用的校验分片需保持长度一致
+		for i := m.DataShards; i < len(shards); i++ {
+			if shards[i] != nil && int64(len(shards[i])) != shardSize {
+				shards[i] = nil
+			}
+		}
+		if err := rs.Reconstruct(shards); err != nil {
+			return fail(err)
+		}
+		stats.Reconstructed += lost
+	}
+	for _, p := range data {
+		if _, err := out.Write(shards[p.Shard][:p.Size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// botChunkStore 使用短视频与合并转发消息保存分块
+type botChunkStore struct {
+	bot *CQBot
+}
+
+// FindPart 使用 data/videos 中的短视频记录作为分块索引, 开启 video_record.probe 时将确认分块仍然有效
+func (s *botChunkStore) FindPart(ctx context.Context, md5 string, size int64) *message.ShortVideoElement {
+	v, err := readVideoRecord(path.Join(global.VideoPath, md5+".video"))
+	if err != nil || v.File != "" || int64(uint32(v.Size)) != size {
+		return nil
+	}
+	if s.bot.videoProbe && s.bot.Client.GetShortVideoUrl(v.Uuid, v.Md5) == "" {
+		Logger(ctx).Debugf("分块 %v 的记录已失效, 将重新上传", md5)
+		return nil
+	}
+	return &v.ShortVideoElement
+}
+
+func (s *botChunkStore) UploadPart(ctx context.Context, data []byte) (*message.ShortVideoElement, error) {
+	sum := md5.Sum(data)
+	cacheFile := path.Join(global.CachePath, hex.EncodeToString(sum[:])+".cache")
+	defer global.Cache.Acquire(cacheFile)()
+	start := time.Now()
+	v, err := s.bot.Client.UploadGroupShortVideo(0, bytes.NewReader(data), bytes.NewReader(nil), cacheFile)
+	s.bot.transfer.upload(int64(len(data)), start, err)
+	if err == nil {
+		if _, err := writeVideoRecord(v, ""); err != nil {
+			Logger(ctx).Warnf("保存分块记录时出现错误: %v", err)
+		}
+	}
+	return v, err
+}
+
+func (s *botChunkStore) DownloadPart(ctx context.Context, v *message.ShortVideoElement) ([]byte, error) {
+	url := s.bot.Client.GetShortVideoUrl(v.Uuid, v.Md5)
+	if url == "" {
+		return nil, errPartMissing
+	}
+	start := time.Now()
+	b, err := global.GetBytes(ctx, url, int64(uint32(v.Size))+1024)
+	s.bot.transfer.download(int64(len(b)), start, err)
+	return b, err
+}
+
+func (s *botChunkStore) UploadManifest(ctx context.Context, nodes []*message.ForwardNode) (string, error) {
+	ret := s.bot.Client.UploadForwardMessage(&message.ForwardMessage{Nodes: nodes})
+	if ret == nil {
+		return "", errors.New("合并转发消息上传失败: 账号可能被风控")
+	}
+	if err := writeForwardRecord(ret.ResId, len(nodes)); err != nil {
+		Logger(ctx).Warnf("保存合并转发记录时出现错误: %v", err)
+	}
+	s.bot.Publish(&ManifestCreatedEvent{
+		Time:      time.Now().Unix(),
+		SelfID:    s.bot.selfID(),
+		MessageID: ret.ResId,
+		NodeCount: len(nodes),
+	})
+	return ret.ResId, nil
+}
+
+func (s *botChunkStore) GetManifest(_ context.Context, resID string) (*message.ForwardMessage, error) {
+	fm := s.bot.Client.GetForwardMessage(resID)
+	if fm == nil {
+		return nil, fmt.Errorf("合并转发消息 %v 不存在: %w", resID, global.ErrRemoteMissing)
+	}
+	return fm, nil
+}
+
+// UploadChunkedFile 将本地文件分块上传为短视频, 返回分块清单与合并转发 ResID
+func (bot *CQBot) UploadChunkedFile(ctx context.Context, file string, opt ChunkOptions) (*ChunkManifest, string, *ChunkUploadStats, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer f.Close()
+	sender := &message.ForwardNode{SenderId: bot.Client.Uin, SenderName: bot.Client.Nickname, Time: int32(time.Now().Unix())}
+	m, resID, stats, err := uploadChunked(ctx, &botChunkStore{bot: bot}, filepath.Base(file), f, opt, sender)
+	if err == nil {
+		if err := writeChunkedFileRecord(resID, m); err != nil {
+			Logger(ctx).Warnf("保存分块文件记录时出现错误: %v", err)
+		}
+	}
+	return m, resID, stats, err
+}
+
+// DownloadChunkedFile 下载分块文件到 file, 失败时不会留下不完整的文件
+func (bot *CQBot) DownloadChunkedFile(ctx context.Context, resID, file string) (*ChunkManifest, *ChunkDownloadStats, error) {
+	tmp := file + ".downloading"
+	// 下载到缓存目录时, 防止未完成的临时文件被缓存淘汰删除
+	release := global.Cache.Acquire(tmp)
+	defer release()
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, stats, err := downloadChunked(ctx, &botChunkStore{bot: bot}, resID, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return m, stats, err
+	}
+	return m, stats, os.Rename(tmp, file)
+}
@@ -0,0 +1,131 @@
+package coolq
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/sam01101/MiraiGo-qdrive/message"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestChunkedParity(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	data := make([]byte, 10*1000+123)
+	rnd.Read(data)
+	store := newFakeChunkStore()
+	sender := &message.ForwardNode{SenderId: 1, SenderName: "test"}
+	m, resID, _, err := uploadChunked(context.Background(), store, "a.bin", bytes.NewReader(data),
+		ChunkOptions{PartSize: 1000, DataShards: 4, ParityShards: 2}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 11 个数据分块, 3 个条带, 每个条带 2 个校验分块
+	if m.Size != int64(len(data)) || len(m.Parts) != 11+3*2 {
+		t.Fatalf("unexpected manifest: size %d parts %d", m.Size, len(m.Parts))
+	}
+
+	// 每个条带随机删除或损坏两个分块
+	stripes := map[int][]ChunkPart{}
+	for _, p := range m.Parts {
+		stripes[p.Stripe] = append(stripes[p.Stripe], p)
+	}
+	for s := 0; s < len(stripes); s++ {
+		ps := stripes[s]
+		for n, i := range rnd.Perm(len(ps))[:2] {
+			if n == 0 {
+				delete(store.parts, ps[i].Md5)
+			} else if b, ok := store.parts[ps[i].Md5]; ok {
+				b[0] ^= 0xff
+			}
+		}
+	}
+	var out bytes.Buffer
+	_, stats, err := downloadChunked(context.Background(), store, resID, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("reconstructed data mismatch")
+	}
+	if stats.Missing+stats.Damaged == 0 || stats.Reconstructed == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 无校验分块时丢失分块将失败
+	m, resID, _, err = uploadChunked(context.Background(), store, "b.bin", bytes.NewReader(data[:2500]),
+		ChunkOptions{PartSize: 1000}, sender)
+	if err != nil || len(m.Parts) != 3 {
+		t.Fatalf("unexpected manifest %+v: %v", m, err)
+	}
+	delete(store.parts, m.Parts[1].Md5)
+	if _, _, err = downloadChunked(context.Background(), store, resID, ioutil.Discard); err == nil {
+		t.Fatal("download without parity should fail")
+	}
+}
+
+func TestChunkedCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	store := newFakeChunkStore()
+	sender := &message.ForwardNode{SenderId: 1, SenderName: "test"}
+	m, resID, _, err := uploadChunked(context.Background(), store, "a.txt", bytes.NewReader(data),
+		ChunkOptions{PartSize: 1000, DataShards: 2, ParityShards: 1, Compression: global.CompressionAuto}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Compression != global.CompressionGzip || m.Size != int64(len(data)) || m.StoredSize >= m.Size {
+		t.Fatalf("unexpected manifest: compression %q size %d stored %d", m.Compression, m.Size, m.StoredSize)
+	}
+	delete(store.parts, m.Parts[0].Md5)
+	var out bytes.Buffer
+	if _, _, err = downloadChunked(context.Background(), store, resID, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("decompressed data mismatch")
+	}
+
+	// 已压缩的格式不再压缩
+	m, _, _, err = uploadChunked(context.Background(), store, "a.zip", bytes.NewReader(data),
+		ChunkOptions{PartSize: 1000, Compression: global.CompressionGzip}, sender)
+	if err != nil || m.Compression != "" || m.StoredSize != m.Size {
+		t.Fatalf("unexpected manifest %+v: %v", m, err)
+	}
+}
+
+func TestChunkedDedup(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	data := make([]byte, 64*1024)
+	rnd.Read(data)
+	store := newFakeChunkStore()
+	sender := &message.ForwardNode{SenderId: 1, SenderName: "test"}
+	opt := ChunkOptions{PartSize: 2048, Chunking: ChunkingCDC}
+	m, _, stats, err := uploadChunked(context.Background(), store, "a.bin", bytes.NewReader(data), opt, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Chunking != ChunkingCDC || stats.Reused != 0 || store.uploads != len(m.Parts) {
+		t.Fatalf("unexpected first upload: %+v, %d uploads", stats, store.uploads)
+	}
+
+	// 中部插入数据后只需上传附近的分块
+	edited := append(append(append([]byte(nil), data[:30000]...), "changed"...), data[30000:]...)
+	store.uploads = 0
+	m, resID, stats, err := uploadChunked(context.Background(), store, "a.bin", bytes.NewReader(edited), opt, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.uploads == 0 || store.uploads > 3 || stats.Reused+store.uploads != len(m.Parts) {
+		t.Fatalf("unexpected second upload: %+v, %d uploads of %d parts", stats, store.uploads, len(m.Parts))
+	}
+	var out bytes.Buffer
+	if _, _, err = downloadChunked(context.Background(), store, resID, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), edited) {
+		t.Fatal("downloaded data mismatch")
+	}
+}
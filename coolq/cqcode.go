@@ -18,7 +18,6 @@ import (
 	"strings"
 	"unsafe"
 
-	"github.com/sam01101/MiraiGo-qdrive/binary"
 	"github.com/sam01101/MiraiGo-qdrive/message"
 	"github.com/sam01101/MiraiGo-qdrive/utils"
 	"github.com/sam01101/gocq-qqdrive/global"
@@ -63,8 +62,50 @@ const maxVideoSize = 1024 * 1024 * 100 // 100MB
 // LocalVideoElement 本地视频
 type LocalVideoElement struct {
 	message.ShortVideoElement
-	File  string
-	thumb io.ReadSeeker
+	File    string
+	thumb   io.ReadSeeker
+	record  string // 复用的短视频记录路径
+	source  string // 短视频记录保存的本地源文件路径
+	release func() // 释放 File 所在的缓存文件
+}
+
+// Release 释放视频占用的缓存文件, 上传完成或放弃上传后需调用, 在此之前缓存文件不会被淘汰
+func (v *LocalVideoElement) Release() {
+	if v.release != nil {
+		v.release()
+		v.release = nil
+	}
+}
+
+// releaseVideos 释放消息中所有视频占用的缓存文件
+func releaseVideos(elems []message.IMessageElement) {
+	for _, e := range elems {
+		if v, ok := e.(*LocalVideoElement); ok {
+			v.Release()
+		}
+	}
+}
+
+// videoCover 提取视频封面到缓存目录并返回封面数据
+func videoCover(src string) []byte {
+	hash := md5.Sum([]byte(src))
+	cover := path.Join(global.CachePath, hex.EncodeToString(hash[:])+".jpg")
+	defer global.Cache.Acquire(cover)()
+	_ = global.ExtractCover(src, cover)
+	data, _ := ioutil.ReadFile(cover)
+	return data
+}
+
+// downloadCacheFile 下载文件到缓存目录, 下载期间文件不会被淘汰
+func downloadCacheFile(url, file string, limit int64, threadCount int, headers map[string]string) error {
+	defer global.Cache.Acquire(file)()
+	return global.DownloadFileMultiThreading(url, file, limit, threadCount, headers)
+}
+
+// encodeCacheMP4 将视频编码为MP4并写入缓存目录, 编码期间文件不会被淘汰
+func encodeCacheMP4(src, dst string) error {
+	defer global.Cache.Acquire(dst)()
+	return global.EncodeMP4(src, dst)
 }
 
 // ToArrayMessage 将消息元素数组转为MSG数组以用于消息上报
@@ -300,34 +341,37 @@ func (bot *CQBot) ToElement(t string, d map[string]string) (m interface{}, err e
 		if v.File == "" {
 			return v, nil
 		}
-		var data []byte
-		_ = global.ExtractCover(v.File, v.File+".jpg")
-		data, _ = ioutil.ReadFile(v.File + ".jpg")
-		v.thumb = bytes.NewReader(data)
+		v.thumb = bytes.NewReader(videoCover(v.File))
 		video, _ := os.Open(v.File)
 		defer video.Close()
 		_, err = video.Seek(4, io.SeekStart)
 		if err != nil {
+			v.Release()
 			return nil, err
 		}
 		var header = make([]byte, 4)
 		_, err = video.Read(header)
 		if err != nil {
+			v.Release()
 			return nil, err
 		}
 		if !bytes.Equal(header, []byte{0x66, 0x74, 0x79, 0x70}) { // check file header ftyp
 			_, _ = video.Seek(0, io.SeekStart)
 			hash, _ := utils.ComputeMd5AndLength(video)
 			cacheFile := path.Join(global.CachePath, hex.EncodeToString(hash[:])+".mp4")
+			release := global.Cache.Acquire(cacheFile)
 			if global.PathExists(cacheFile) && cache == "1" {
 				goto ok
 			}
-			err = global.EncodeMP4(v.File, cacheFile)
+			err = encodeCacheMP4(v.File, cacheFile)
 			if err != nil {
+				release()
+				v.Release()
 				return nil, err
 			}
 		ok:
-			v.File = cacheFile
+			v.Release()
+			v.File, v.release = cacheFile, release
 		}
 		return v, nil
 	default:
@@ -415,19 +459,22 @@ func (bot *CQBot) makeImageOrVideoElem(d map[string]string, video bool) (message
 		hash := md5.Sum([]byte(f))
 		cacheFile := path.Join(global.CachePath, hex.EncodeToString(hash[:])+".cache")
 		thread, _ := strconv.Atoi(c)
+		release := global.Cache.Acquire(cacheFile)
 		if global.PathExists(cacheFile) && cache == "1" {
 			goto hasCacheFile
 		}
 		if global.PathExists(cacheFile) {
 			_ = os.Remove(cacheFile)
 		}
-		if err := global.DownloadFileMultiThreading(f, cacheFile, maxVideoSize, thread, nil); err != nil {
+		if err := downloadCacheFile(f, cacheFile, maxVideoSize, thread, nil); err != nil {
+			release()
 			return nil, err
 		}
 	hasCacheFile:
 		if video {
-			return &LocalVideoElement{File: cacheFile}, nil
+			return &LocalVideoElement{File: cacheFile, release: release}, nil
 		}
+		release()
 	}
 	if strings.HasPrefix(f, "file") {
 		fu, err := url.Parse(f)
@@ -456,16 +503,7 @@ func (bot *CQBot) makeImageOrVideoElem(d map[string]string, video bool) (message
 		return nil, errors.New("invalid video")
 	}
 	if path.Ext(rawPath) == ".video" {
-		b, _ := ioutil.ReadFile(rawPath)
-		r := binary.NewReader(b)
-		return &LocalVideoElement{ShortVideoElement: message.ShortVideoElement{ // todo 检查缓存是否有效
-			Md5:       r.ReadBytes(16),
-			ThumbMd5:  r.ReadBytes(16),
-			Size:      r.ReadInt32(),
-			ThumbSize: r.ReadInt32(),
-			Name:      r.ReadString(),
-			Uuid:      r.ReadAvailable(),
-		}}, nil
+		return readVideoRecord(rawPath)
 	}
 	return &LocalVideoElement{File: rawPath}, nil
 }
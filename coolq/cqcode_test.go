@@ -2,6 +2,10 @@ package coolq
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sam01101/MiraiGo-qdrive/client"
@@ -16,6 +20,37 @@ func TestCQBot_ConvertStringMessage(t *testing.T) {
 	}
 }
 
+func TestVideoElementHoldsCache(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	_ = os.MkdirAll(global.CachePath, 0755)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("\x00\x00\x00\x18ftypmp42 video"))
+	}))
+	defer ts.Close()
+
+	elem, err := bot.ToElement("video", map[string]string{"file": ts.URL + "/a.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := elem.(*LocalVideoElement)
+	// 上传前清理缓存不能淘汰视频文件
+	if _, err = global.Cache.Clean(true); err != nil {
+		t.Fatal(err)
+	}
+	if !global.PathExists(v.File) || filepath.Dir(v.File) != global.CachePath {
+		t.Fatalf("cached video %v evicted before upload", v.File)
+	}
+	v.Release()
+	v.Release()
+	if stats, _ := global.Cache.Stats(); stats.InUse != 0 {
+		t.Fatalf("%v files still in use after Release", stats.InUse)
+	}
+}
+
 var bench = `asdfqwerqwerqwer[CQ:face,id=115,text=111]asdfasdfasdfasdfasdfasdfasd[CQ:face,id=217]] [CQ:text,text=123] [`
 
 func BenchmarkCQBot_ConvertStringMessage(b *testing.B) {
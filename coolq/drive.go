@@ -0,0 +1,140 @@
+package coolq
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+// ErrRecordNotFound 本地不存在对应的记录
+var ErrRecordNotFound = errors.New("record not found")
+
+// DriveEntry 本地记录的已上传内容
+type DriveEntry struct {
+	Kind string `json:"kind"` // video, file 或 forward
+	ID   string `json:"id"`   // video 为记录文件名, 其余为合并转发ID
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size,omitempty"`
+	Md5  string `json:"md5,omitempty"`
+	Time int64  `json:"time,omitempty"`
+}
+
+// ListDrive 列出 data/videos 中的记录, all 为 false 时不列出分块上传产生的分块记录
+func ListDrive(all bool) ([]DriveEntry, error) {
+	return listDrive(global.VideoPath, all)
+}
+
+func listDrive(dir string, all bool) ([]DriveEntry, error) {
+	entries := []DriveEntry{}
+	videos, err := filepath.Glob(filepath.Join(dir, "*.video"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range videos {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		r, err := decodeVideoRecord(b)
+		if err != nil || (r.FileName == "" && !all) {
+			continue
+		}
+		entries = append(entries, DriveEntry{
+			Kind: "video", ID: filepath.Base(f), Name: r.FileName,
+			Size: r.Size, Md5: hex.EncodeToString(r.Md5), Time: r.UploadedAt,
+		})
+	}
+	forwards, err := filepath.Glob(filepath.Join(dir, "*.forward"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range forwards {
+		r, err := readForwardRecordInfo(f)
+		if err != nil {
+			continue
+		}
+		e := DriveEntry{Kind: "forward", ID: r.ResID, Name: r.Name, Size: r.Size, Md5: r.Md5, Time: r.CreatedAt}
+		if r.Name != "" {
+			e.Kind = "file"
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+	return entries, nil
+}
+
+// driveRecord 获取 id 对应的本地记录文件, id 可为视频记录文件名、视频MD5或合并转发ID
+func driveRecord(dir, id string) (file string, video bool) {
+	if strings.HasSuffix(id, ".video") {
+		return path.Join(dir, filepath.Base(id)), true
+	}
+	if b, err := hex.DecodeString(id); err == nil && len(b) == 16 {
+		if f := path.Join(dir, videoRecordName(b)); global.PathExists(f) {
+			return f, true
+		}
+	}
+	return path.Join(dir, forwardRecordName(id)), false
+}
+
+// RemoveDriveRecord 删除 id 对应的本地记录, 服务器上的内容无法删除
+func RemoveDriveRecord(id string) (string, error) {
+	return removeDriveRecord(global.VideoPath, id)
+}
+
+func removeDriveRecord(dir, id string) (string, error) {
+	file, video := driveRecord(dir, id)
+	if !global.PathExists(file) {
+		return "", ErrRecordNotFound
+	}
+	if err := os.Remove(file); err != nil {
+		return "", err
+	}
+	if video {
+		_ = os.Remove(videoSourcePath(file))
+	}
+	return filepath.Base(file), nil
+}
+
+// DriveInfo 获取 id 对应内容的详细信息, 并向服务器确认其是否仍然可用
+func (bot *CQBot) DriveInfo(id string) (MSG, error) {
+	file, video := driveRecord(global.VideoPath, id)
+	if video {
+		v, err := readVideoRecord(file)
+		if os.IsNotExist(err) {
+			return nil, ErrRecordNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return MSG{
+			"kind":      "video",
+			"id":        filepath.Base(file),
+			"name":      v.Name,
+			"size":      int64(uint32(v.Size)),
+			"md5":       hex.EncodeToString(v.Md5),
+			"source":    v.source,
+			"available": v.File == "" && bot.Client.GetShortVideoUrl(v.Uuid, v.Md5) != "",
+		}, nil
+	}
+	fm := bot.Client.GetForwardMessage(id)
+	if fm == nil {
+		if !global.PathExists(file) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, global.ErrRemoteMissing
+	}
+	if m, _, err := parseChunkManifest(fm); err == nil {
+		return MSG{"kind": "file", "id": id, "manifest": m}, nil
+	}
+	if snap, err := parseSnapshot(fm); err == nil {
+		return MSG{"kind": "snapshot", "id": id, "snapshot_id": snap.ID, "time": snap.Time, "dirs": snap.Dirs, "files": len(snap.Files)}, nil
+	}
+	return MSG{"kind": "forward", "id": id, "nodes": len(fm.Nodes)}, nil
+}
@@ -0,0 +1,50 @@
+package coolq
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDriveRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	md5 := bytes.Repeat([]byte{0xab}, 16)
+	video := &videoRecord{Version: videoRecordVersion, Md5: md5, ThumbMd5: make([]byte, 16), Size: 10, UUID: []byte("a"), FileName: "a.mp4", UploadedAt: 1}
+	part := &videoRecord{Version: videoRecordVersion, Md5: make([]byte, 16), ThumbMd5: make([]byte, 16), Size: 10, UUID: []byte("b"), UploadedAt: 2}
+	_ = ioutil.WriteFile(filepath.Join(dir, videoRecordName(video.Md5)), encodeVideoRecord(video), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, videoRecordName(part.Md5)), encodeVideoRecord(part), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, forwardRecordName("res-file")), []byte("res-file\n3\n3\nb.bin\n20\nffff\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, forwardRecordName("res-fwd")), []byte("res-fwd\n1\n4\n"), 0644)
+
+	entries, err := listDrive(dir, false)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("unexpected entries %+v: %v", entries, err)
+	}
+	if entries[0].Kind != "video" || entries[0].Name != "a.mp4" || entries[1].Kind != "file" || entries[1].Size != 20 || entries[2].Kind != "forward" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if entries, _ = listDrive(dir, true); len(entries) != 4 {
+		t.Fatalf("list all should include parts, got %d", len(entries))
+	}
+
+	if _, err = removeDriveRecord(dir, hex.EncodeToString(md5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = removeDriveRecord(dir, "res-file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = removeDriveRecord(dir, "res-file"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+	if entries, _ = listDrive(dir, false); len(entries) != 1 {
+		t.Fatalf("unexpected entries after rm %+v", entries)
+	}
+}
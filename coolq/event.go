@@ -29,3 +29,163 @@ func ToFormattedMessage(e []message.IMessageElement, isRaw ...bool) (r interface
 	}
 	return
 }
+
+// Event 可上报的事件
+type Event interface {
+	// ToMSG 转换为上报使用的 MSG
+	ToMSG() MSG
+}
+
+// HeartbeatEvent 心跳元事件
+type HeartbeatEvent struct {
+	Time     int64
+	SelfID   int64
+	Interval int64 // 心跳间隔, 单位毫秒
+	Status   *BotStatus
+}
+
+// ToMSG 转换为上报使用的 MSG
+func (e *HeartbeatEvent) ToMSG() MSG {
+	return MSG{
+		"time":            e.Time,
+		"self_id":         e.SelfID,
+		"post_type":       "meta_event",
+		"meta_event_type": "heartbeat",
+		"interval":        e.Interval,
+		"status":          e.Status,
+	}
+}
+
+// LifecycleEvent 生命周期元事件
+type LifecycleEvent struct {
+	Time    int64
+	SelfID  int64
+	SubType string // enable, disable 或 connect
+}
+
+// ToMSG 转换为上报使用的 MSG
+func (e *LifecycleEvent) ToMSG() MSG {
+	return MSG{
+		"time":            e.Time,
+		"self_id":         e.SelfID,
+		"post_type":       "meta_event",
+		"meta_event_type": "lifecycle",
+		"sub_type":        e.SubType,
+	}
+}
+
+// UploadCompleteEvent 短视频上传完成通知
+type UploadCompleteEvent struct {
+	Time     int64
+	SelfID   int64
+	FileName string // 本地 .video 记录文件名
+	FileMd5  string // hex 编码
+	Size     int64
+}
+
+// ToMSG 转换为上报使用的 MSG
+func (e *UploadCompleteEvent) ToMSG() MSG {
+	return MSG{
+		"time":        e.Time,
+		"self_id":     e.SelfID,
+		"post_type":   "notice",
+		"notice_type": "upload_complete",
+		"file_name":   e.FileName,
+		"file_md5":    e.FileMd5,
+		"size":        e.Size,
+	}
+}
+
+// ManifestCreatedEvent 合并转发清单创建通知
+type ManifestCreatedEvent struct {
+	Time      int64
+	SelfID    int64
+	MessageID string // 合并转发 ResID
+	NodeCount int
+}
+
+// ToMSG 转换为上报使用的 MSG
+func (e *ManifestCreatedEvent) ToMSG() MSG {
+	return MSG{
+		"time":        e.Time,
+		"self_id":     e.SelfID,
+		"post_type":   "notice",
+		"notice_type": "manifest_created",
+		"message_id":  e.MessageID,
+		"node_count":  e.NodeCount,
+	}
+}
+
+// ParseEvent 将 MSG 转换为对应的 Event, 不支持的事件类型返回 false
+func ParseEvent(m MSG) (Event, bool) {
+	str := func(k string) string {
+		s, _ := m[k].(string)
+		return s
+	}
+	switch str("post_type") + "/" + str(str("post_type")+"_type") {
+	case "meta_event/heartbeat":
+		return &HeartbeatEvent{
+			Time:     msgInt(m["time"]),
+			SelfID:   msgInt(m["self_id"]),
+			Interval: msgInt(m["interval"]),
+			Status:   parseBotStatus(m["status"]),
+		}, true
+	case "meta_event/lifecycle":
+		return &LifecycleEvent{Time: msgInt(m["time"]), SelfID: msgInt(m["self_id"]), SubType: str("sub_type")}, true
+	case "notice/upload_complete":
+		return &UploadCompleteEvent{
+			Time:     msgInt(m["time"]),
+			SelfID:   msgInt(m["self_id"]),
+			FileName: str("file_name"),
+			FileMd5:  str("file_md5"),
+			Size:     msgInt(m["size"]),
+		}, true
+	case "notice/manifest_created":
+		return &ManifestCreatedEvent{
+			Time:      msgInt(m["time"]),
+			SelfID:    msgInt(m["self_id"]),
+			MessageID: str("message_id"),
+			NodeCount: int(msgInt(m["node_count"])),
+		}, true
+	case "notice/health_check":
+		issues, _ := m["issues"].([]HealthIssue)
+		return &HealthCheckEvent{
+			Time:    msgInt(m["time"]),
+			SelfID:  msgInt(m["self_id"]),
+			Checked: int(msgInt(m["checked"])),
+			Issues:  issues,
+		}, true
+	}
+	return nil, false
+}
+
+func parseBotStatus(v interface{}) *BotStatus {
+	switch st := v.(type) {
+	case *BotStatus:
+		return st
+	case MSG:
+		return parseBotStatus(map[string]interface{}(st))
+	case map[string]interface{}:
+		online, _ := st["online"].(bool)
+		good, _ := st["good"].(bool)
+		return &BotStatus{Online: online, Good: good}
+	}
+	return nil
+}
+
+// msgInt 读取 MSG 中的整数, 兼容 JSON 解码得到的 float64
+func msgInt(v interface{}) int64 {
+	switch i := v.(type) {
+	case int:
+		return int64(i)
+	case int32:
+		return int64(i)
+	case int64:
+		return i
+	case uint32:
+		return int64(i)
+	case float64:
+		return int64(i)
+	}
+	return 0
+}
@@ -0,0 +1,235 @@
+package coolq
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sam01101/MiraiGo-qdrive/message"
+	"github.com/sam01101/gocq-qqdrive/global"
+	log "github.com/sirupsen/logrus"
+)
+
+// 健康检查问题类型
+const (
+	HealthMissing = "missing" // 服务器上已不存在
+	HealthDamaged = "damaged" // 本地记录损坏或无法读取
+)
+
+// HealthIssue 健康检查发现的问题
+type HealthIssue struct {
+	File   string `json:"file"`   // 本地记录文件名
+	Kind   string `json:"kind"`   // video 或 forward
+	Status string `json:"status"` // missing 或 damaged
+	Reason string `json:"reason"`
+}
+
+// HealthReport 健康检查报告
+type HealthReport struct {
+	StartedAt  int64         `json:"started_at"`
+	FinishedAt int64         `json:"finished_at"`
+	Checked    int           `json:"checked"`
+	Issues     []HealthIssue `json:"issues"`
+}
+
+// HealthCheckEvent 健康检查发现问题时的通知
+type HealthCheckEvent struct {
+	Time    int64
+	SelfID  int64
+	Checked int
+	Issues  []HealthIssue
+}
+
+// ToMSG 转换为上报使用的 MSG
+func (e *HealthCheckEvent) ToMSG() MSG {
+	return MSG{
+		"time":        e.Time,
+		"self_id":     e.SelfID,
+		"post_type":   "notice",
+		"notice_type": "health_check",
+		"checked":     e.Checked,
+		"issues":      e.Issues,
+	}
+}
+
+// healthSource 健康检查所需的客户端接口
+type healthSource interface {
+	GetShortVideoUrl(uuid, md5 []byte) string
+	GetForwardMessage(resID string) *message.ForwardMessage
+}
+
+// HealthChecker 定期检查本地记录对应的文件在服务器上是否仍然可用
+type HealthChecker struct {
+	Dir         string
+	Concurrency int
+	RangeFetch  int64 // 大于0时下载视频的前 RangeFetch 字节确认可用
+
+	lock    sync.Mutex
+	running sync.Mutex
+	last    *HealthReport
+	stop    chan struct{}
+}
+
+// Health 全局健康检查器
+var Health = &HealthChecker{Dir: global.VideoPath, Concurrency: 4}
+
+// Report 获取最近一次健康检查报告, 未检查过时返回 nil
+func (h *HealthChecker) Report() *HealthReport {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.last
+}
+
+// Run 检查所有本地记录, 发现问题时由 bot 上报 health_check 通知
+func (h *HealthChecker) Run(ctx context.Context, bot *CQBot) (*HealthReport, error) {
+	h.running.Lock()
+	defer h.running.Unlock()
+	r, err := h.check(ctx, bot.Client)
+	if err != nil {
+		return nil, err
+	}
+	h.lock.Lock()
+	h.last = r
+	h.lock.Unlock()
+	if len(r.Issues) > 0 {
+		log.Warnf("健康检查完成: 共检查 %v 个记录, 发现 %v 个问题", r.Checked, len(r.Issues))
+		bot.Publish(&HealthCheckEvent{Time: r.FinishedAt, SelfID: bot.selfID(), Checked: r.Checked, Issues: r.Issues})
+	} else {
+		log.Infof("健康检查完成: 共检查 %v 个记录, 未发现问题", r.Checked)
+	}
+	return r, nil
+}
+
+func (h *HealthChecker) check(ctx context.Context, src healthSource) (*HealthReport, error) {
+	r := &HealthReport{StartedAt: time.Now().Unix(), Issues: []HealthIssue{}}
+	var files []string
+	for _, pattern := range []string{"*.video", "*.forward"} {
+		m, err := filepath.Glob(filepath.Join(h.Dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, m...)
+	}
+	n := h.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		sem  = make(chan struct{}, n)
+	)
+	for _, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(f string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			issue := h.checkFile(ctx, src, f)
+			lock.Lock()
+			r.Checked++
+			if issue != nil {
+				r.Issues = append(r.Issues, *issue)
+			}
+			lock.Unlock()
+		}(f)
+	}
+	wg.Wait()
+	sort.Slice(r.Issues, func(i, j int) bool { return r.Issues[i].File < r.Issues[j].File })
+	r.FinishedAt = time.Now().Unix()
+	return r, ctx.Err()
+}
+
+func (h *HealthChecker) checkFile(ctx context.Context, src healthSource, file string) *HealthIssue {
+	issue := &HealthIssue{File: filepath.Base(file), Kind: "video"}
+	if filepath.Ext(file) == ".forward" {
+		issue.Kind = "forward"
+		resID, err := readForwardRecord(file)
+		if err != nil {
+			issue.Status, issue.Reason = HealthDamaged, err.Error()
+			return issue
+		}
+		if src.GetForwardMessage(resID) == nil {
+			issue.Status, issue.Reason = HealthMissing, "合并转发消息不存在"
+			return issue
+		}
+		return nil
+	}
+	v, err := readVideoRecord(file)
+	if err == nil && v.File != "" {
+		err = errInvalidVideoRecord
+	}
+	if err != nil {
+		issue.Status, issue.Reason = HealthDamaged, err.Error()
+		return issue
+	}
+	url := src.GetShortVideoUrl(v.Uuid, v.Md5)
+	if url == "" {
+		issue.Status, issue.Reason = HealthMissing, "无法获取视频链接"
+		return issue
+	}
+	if h.RangeFetch > 0 {
+		if err := global.ProbeURL(ctx, url, h.RangeFetch); err != nil {
+			issue.Status, issue.Reason = HealthDamaged, err.Error()
+			if errors.Is(err, global.ErrRemoteMissing) {
+				issue.Status = HealthMissing
+			}
+			return issue
+		}
+	}
+	return nil
+}
+
+// Start 每隔 interval 执行一次健康检查, 重复调用将替换之前的定时任务, bot 关闭后定时任务随之停止
+func (h *HealthChecker) Start(bot *CQBot, interval time.Duration) {
+	h.lock.Lock()
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	if interval <= 0 {
+		h.lock.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	h.stop = stop
+	h.lock.Unlock()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-bot.closed:
+				return
+			case <-t.C:
+			}
+			if !bot.Client.Online {
+				continue
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-stop:
+					cancel()
+				case <-bot.closed:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			if _, err := h.Run(ctx, bot); err != nil && ctx.Err() == nil {
+				log.Warnf("健康检查时出现错误: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+package coolq
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "videos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(404)
+			return
+		}
+		if r.Header.Get("Range") != "bytes=0-15" {
+			t.Errorf("unexpected range %q", r.Header.Get("Range"))
+		}
+		w.WriteHeader(206)
+		_, _ = w.Write(make([]byte, 16))
+	}))
+	defer srv.Close()
+
+	writeVideo := func(name, uuid string) {
+		r := &videoRecord{Version: videoRecordVersion, Md5: make([]byte, 16), ThumbMd5: make([]byte, 16), Size: 10, UUID: []byte(uuid)}
+		_ = ioutil.WriteFile(filepath.Join(dir, name+".video"), encodeVideoRecord(r), 0644)
+	}
+	writeVideo("ok", "ok")
+	writeVideo("expired", "expired")
+	writeVideo("gone", "gone")
+	_ = ioutil.WriteFile(filepath.Join(dir, "broken.video"), []byte("xx"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "a.forward"), []byte("res-a\n1\n0\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "b.forward"), []byte("res-b\n1\n0\n"), 0644)
+
+	src := &fakeHealthSource{
+		urls:     map[string]string{"ok": srv.URL + "/ok", "gone": srv.URL + "/gone"},
+		forwards: map[string]bool{"res-a": true},
+	}
+	h := &HealthChecker{Dir: dir, Concurrency: 2, RangeFetch: 16}
+	r, err := h.check(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"b.forward":     HealthMissing,
+		"broken.video":  HealthDamaged,
+		"expired.video": HealthMissing,
+		"gone.video":    HealthMissing,
+	}
+	if r.Checked != 6 || len(r.Issues) != len(want) {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	for _, issue := range r.Issues {
+		if want[issue.File] != issue.Status {
+			t.Fatalf("unexpected issue %+v", issue)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package coolq
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"github.com/sam01101/MiraiGo-qdrive/message"
+)
+
+type fakeHealthSource struct {
+	urls     map[string]string
+	forwards map[string]bool
+}
+
+func (f *fakeHealthSource) GetShortVideoUrl(uuid, _ []byte) string {
+	return f.urls[string(uuid)]
+}
+
+func (f *fakeHealthSource) GetForwardMessage(resID string) *message.ForwardMessage {
+	if f.forwards[resID] {
+		return &message.ForwardMessage{}
+	}
+	return nil
+}
+
+type fakeChunkStore struct {
+	parts    map[string][]byte
+	manifest map[string]*message.ForwardMessage
+	uploads  int
+}
+
+func newFakeChunkStore() *fakeChunkStore {
+	return &fakeChunkStore{parts: map[string][]byte{}, manifest: map[string]*message.ForwardMessage{}}
+}
+
+func (f *fakeChunkStore) FindPart(_ context.Context, sum string, size int64) *message.ShortVideoElement {
+	if b, ok := f.parts[sum]; !ok || int64(len(b)) != size {
+		return nil
+	}
+	v := &message.ShortVideoElement{Uuid: []byte(sum), Size: int32(size)}
+	v.Md5, _ = hex.DecodeString(sum)
+	return v
+}
+
+func (f *fakeChunkStore) UploadPart(ctx context.Context, data []byte) (*message.ShortVideoElement, error) {
+	f.uploads++
+	f.parts[md5Hex(data)] = append([]byte(nil), data...)
+	return f.FindPart(ctx, md5Hex(data), int64(len(data))), nil
+}
+
+func (f *fakeChunkStore) DownloadPart(_ context.Context, v *message.ShortVideoElement) ([]byte, error) {
+	b, ok := f.parts[string(v.Uuid)]
+	if !ok {
+		return nil, errPartMissing
+	}
+	return b, nil
+}
+
+func (f *fakeChunkStore) UploadManifest(_ context.Context, nodes []*message.ForwardNode) (string, error) {
+	id := strconv.Itoa(len(f.manifest))
+	f.manifest[id] = &message.ForwardMessage{Nodes: nodes}
+	return id, nil
+}
+
+func (f *fakeChunkStore) GetManifest(_ context.Context, resID string) (*message.ForwardMessage, error) {
+	if fm, ok := f.manifest[resID]; ok {
+		return fm, nil
+	}
+	return nil, errors.New("not found")
+}
@@ -0,0 +1,40 @@
+package coolq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type logFieldsKey struct{}
+
+// NewRequestID 生成随机的请求ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithLogFields 返回携带附加日志字段的 ctx, 字段将出现在 Logger(ctx) 输出的每条日志中
+func WithLogFields(ctx context.Context, fields log.Fields) context.Context {
+	merged := log.Fields{}
+	if old, ok := ctx.Value(logFieldsKey{}).(log.Fields); ok {
+		for k, v := range old {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, logFieldsKey{}, merged)
+}
+
+// Logger 返回携带 ctx 中日志字段(如 request_id)的日志记录器
+func Logger(ctx context.Context) *log.Entry {
+	if fields, ok := ctx.Value(logFieldsKey{}).(log.Fields); ok {
+		return log.WithFields(fields)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
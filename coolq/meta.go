@@ -0,0 +1,55 @@
+package coolq
+
+import (
+	"time"
+)
+
+// 生命周期元事件的 sub_type
+const (
+	LifecycleEnable  = "enable"
+	LifecycleDisable = "disable"
+	LifecycleConnect = "connect"
+)
+
+// BotStatus 心跳中携带的运行状态
+type BotStatus struct {
+	Online      bool              `json:"online"`
+	Good        bool              `json:"good"`
+	EventQueues []EventQueueStats `json:"event_queues,omitempty"`
+}
+
+// Status 获取当前运行状态
+func (bot *CQBot) Status() *BotStatus {
+	online := bot.Client != nil && bot.Client.Online
+	return &BotStatus{
+		Online:      online,
+		Good:        online,
+		EventQueues: bot.EventQueueStats(),
+	}
+}
+
+// Lifecycle 生成生命周期元事件, subType 为 LifecycleEnable, LifecycleDisable 或 LifecycleConnect
+func (bot *CQBot) Lifecycle(subType string) *LifecycleEvent {
+	return &LifecycleEvent{
+		Time:    time.Now().Unix(),
+		SelfID:  bot.selfID(),
+		SubType: subType,
+	}
+}
+
+// Heartbeat 生成携带运行状态的心跳元事件
+func (bot *CQBot) Heartbeat(interval time.Duration) *HeartbeatEvent {
+	return &HeartbeatEvent{
+		Time:     time.Now().Unix(),
+		SelfID:   bot.selfID(),
+		Interval: interval.Milliseconds(),
+		Status:   bot.Status(),
+	}
+}
+
+func (bot *CQBot) selfID() int64 {
+	if bot.Client == nil {
+		return 0
+	}
+	return bot.Client.Uin
+}
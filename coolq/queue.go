@@ -0,0 +1,157 @@
+package coolq
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 事件队列溢出策略
+const (
+	OverflowBlock      = "block"       // 阻塞上报方直到队列有空位
+	OverflowDropOldest = "drop_oldest" // 丢弃队列中最旧的事件
+	OverflowDropNewest = "drop_newest" // 丢弃新到达的事件
+)
+
+// DefaultEventQueueSize 默认每个订阅者的事件队列长度
+const DefaultEventQueueSize = 256
+
+// eventQueue 订阅者的有界事件队列, 由单个 worker 按顺序处理
+type eventQueue struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	buf      []MSG
+	size     int
+	overflow string
+	closed   bool
+	busy     bool
+
+	delivered uint64
+	dropped   uint64
+}
+
+// EventQueueStats 事件队列统计
+type EventQueueStats struct {
+	ID        uint64 `json:"id"`
+	Name      string `json:"name"`
+	Depth     int    `json:"depth"`
+	Capacity  int    `json:"capacity"`
+	Overflow  string `json:"overflow"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+func newEventQueue(size int, overflow string) *eventQueue {
+	if size <= 0 {
+		size = DefaultEventQueueSize
+	}
+	switch overflow {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+	default:
+		overflow = OverflowDropOldest
+	}
+	q := &eventQueue{size: size, overflow: overflow}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// push 将事件放入队列, 返回事件是否被接受
+func (q *eventQueue) push(m MSG) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for !q.closed && len(q.buf) >= q.size {
+		switch q.overflow {
+		case OverflowBlock:
+			q.cond.Wait()
+			continue
+		case OverflowDropOldest:
+			q.buf[0] = nil
+			q.buf = q.buf[1:]
+			q.dropped++
+		case OverflowDropNewest:
+			q.dropped++
+			return false
+		}
+	}
+	if q.closed {
+		return false
+	}
+	q.buf = append(q.buf, m)
+	q.cond.Broadcast()
+	return true
+}
+
+// pop 取出下一个事件, 队列关闭后返回 false
+func (q *eventQueue) pop() (MSG, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for !q.closed && len(q.buf) == 0 {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	m := q.buf[0]
+	q.buf[0] = nil
+	q.buf = q.buf[1:]
+	q.delivered++
+	q.busy = true
+	q.cond.Broadcast()
+	return m, true
+}
+
+// close 关闭队列, 未处理的事件将被丢弃, 阻塞中的 push 将返回
+func (q *eventQueue) close() {
+	q.lock.Lock()
+	q.closed = true
+	q.buf = nil
+	q.lock.Unlock()
+	q.cond.Broadcast()
+}
+
+// run 按顺序处理队列中的事件直到队列关闭
+func (q *eventQueue) run(fn func(MSG)) {
+	for {
+		m, ok := q.pop()
+		if !ok {
+			return
+		}
+		func() {
+			defer func() {
+				if pan := recover(); pan != nil {
+					log.Warnf("处理事件 %v 时出现错误: %v \n%s", m, pan, debug.Stack())
+				}
+			}()
+			start := time.Now()
+			fn(m)
+			end := time.Now()
+			if end.Sub(start) > time.Second*5 {
+				log.Debugf("警告: 事件处理耗时超过 5 秒 (%v), 请检查应用是否有堵塞.", end.Sub(start))
+			}
+		}()
+		q.lock.Lock()
+		q.busy = false
+		q.lock.Unlock()
+	}
+}
+
+// idle 队列为空且没有正在处理的事件
+func (q *eventQueue) idle() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.closed || (len(q.buf) == 0 && !q.busy)
+}
+
+func (q *eventQueue) stats() EventQueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return EventQueueStats{
+		Depth:     len(q.buf),
+		Capacity:  q.size,
+		Overflow:  q.overflow,
+		Delivered: q.delivered,
+		Dropped:   q.dropped,
+	}
+}
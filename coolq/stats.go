@@ -0,0 +1,70 @@
+package coolq
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+var (
+	transferBytes    = global.NewCounterVec("gocq_transfer_bytes_total", "Bytes successfully uploaded or downloaded.", "direction")
+	transferDuration = global.NewHistogramVec("gocq_transfer_duration_seconds", "Latency of uploads and downloads.", global.DefaultLatencyBuckets, "direction", "result")
+)
+
+// transferStats 上传/下载统计, 所有字段均以原子操作访问
+type transferStats struct {
+	uploadSuccess   uint64
+	uploadFailed    uint64
+	uploadBytes     uint64
+	downloadSuccess uint64
+	downloadFailed  uint64
+	downloadBytes   uint64
+}
+
+// TransferCount 单方向的传输统计
+type TransferCount struct {
+	Success uint64 `json:"success"`
+	Failed  uint64 `json:"failed"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+func (s *transferStats) upload(size int64, start time.Time, err error) {
+	transferDuration.Observe(time.Since(start).Seconds(), "upload", global.ResultLabel(err))
+	if err != nil {
+		atomic.AddUint64(&s.uploadFailed, 1)
+		return
+	}
+	atomic.AddUint64(&s.uploadSuccess, 1)
+	atomic.AddUint64(&s.uploadBytes, uint64(size))
+	transferBytes.Add(float64(size), "upload")
+}
+
+func (s *transferStats) download(size int64, start time.Time, err error) {
+	transferDuration.Observe(time.Since(start).Seconds(), "download", global.ResultLabel(err))
+	if err != nil {
+		atomic.AddUint64(&s.downloadFailed, 1)
+		return
+	}
+	atomic.AddUint64(&s.downloadSuccess, 1)
+	atomic.AddUint64(&s.downloadBytes, uint64(size))
+	transferBytes.Add(float64(size), "download")
+}
+
+// Uploads 返回上传统计
+func (bot *CQBot) Uploads() TransferCount {
+	return TransferCount{
+		Success: atomic.LoadUint64(&bot.transfer.uploadSuccess),
+		Failed:  atomic.LoadUint64(&bot.transfer.uploadFailed),
+		Bytes:   atomic.LoadUint64(&bot.transfer.uploadBytes),
+	}
+}
+
+// Downloads 返回下载统计
+func (bot *CQBot) Downloads() TransferCount {
+	return TransferCount{
+		Success: atomic.LoadUint64(&bot.transfer.downloadSuccess),
+		Failed:  atomic.LoadUint64(&bot.transfer.downloadFailed),
+		Bytes:   atomic.LoadUint64(&bot.transfer.downloadBytes),
+	}
+}
@@ -0,0 +1,445 @@
+package coolq
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	goBinary "encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sam01101/MiraiGo-qdrive/binary"
+	"github.com/sam01101/MiraiGo-qdrive/message"
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+// errInvalidVideoRecord 视频记录文件结构错误
+var errInvalidVideoRecord = errors.New("invalid video record")
+
+// 短视频记录格式
+//
+// 旧格式(版本0): md5(16) thumb_md5(16) size(u32) thumb_size(u32) name(string) uuid(剩余全部)
+//
+// 版本1: magic(4) version(u16) md5(16) thumb_md5(16) size(u64) thumb_size(u64) name uuid
+// file_name mime source(均为 u32长度+数据) uploaded_at(i64) encrypted(u8) [algorithm key_id nonce] crc32(u32)
+//
+// 其中 crc32 为之前所有字节的 IEEE CRC32, 所有整数均为大端序
+const (
+	videoRecordMagic   = "GQVR"
+	videoRecordVersion = 1
+)
+
+// videoRecord 短视频记录
+type videoRecord struct {
+	Version    uint16
+	Md5        []byte
+	ThumbMd5   []byte
+	Size       int64
+	ThumbSize  int64
+	Name       string
+	UUID       []byte
+	FileName   string // 原始文件名
+	MIME       string
+	Source     string // 上传时的本地源文件路径, 用于失效后重新上传
+	UploadedAt int64
+	Encryption *videoEncryption
+}
+
+// videoEncryption 视频加密信息, 仅作记录, 不在此处加解密
+type videoEncryption struct {
+	Algorithm string
+	KeyID     string
+	Nonce     []byte
+}
+
+func (r *videoRecord) element() message.ShortVideoElement {
+	return message.ShortVideoElement{
+		Md5:       r.Md5,
+		ThumbMd5:  r.ThumbMd5,
+		Size:      int32(r.Size),
+		ThumbSize: int32(r.ThumbSize),
+		Name:      r.Name,
+		Uuid:      r.UUID,
+	}
+}
+
+func writeBytes(w *binary.Writer, b []byte) {
+	w.WriteUInt32(uint32(len(b)))
+	w.Write(b)
+}
+
+// encodeVideoRecord 以最新版本格式编码短视频记录
+func encodeVideoRecord(r *videoRecord) []byte {
+	b := binary.NewWriterF(func(w *binary.Writer) {
+		w.Write([]byte(videoRecordMagic))
+		w.WriteUInt16(videoRecordVersion)
+		w.Write(r.Md5)
+		w.Write(r.ThumbMd5)
+		w.WriteUInt64(uint64(r.Size))
+		w.WriteUInt64(uint64(r.ThumbSize))
+		writeBytes(w, []byte(r.Name))
+		writeBytes(w, r.UUID)
+		writeBytes(w, []byte(r.FileName))
+		writeBytes(w, []byte(r.MIME))
+		writeBytes(w, []byte(r.Source))
+		w.WriteUInt64(uint64(r.UploadedAt))
+		if e := r.Encryption; e != nil {
+			w.WriteByte(1)
+			writeBytes(w, []byte(e.Algorithm))
+			writeBytes(w, []byte(e.KeyID))
+			writeBytes(w, e.Nonce)
+		} else {
+			w.WriteByte(0)
+		}
+	})
+	crc := make([]byte, 4)
+	goBinary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(b))
+	return append(b, crc...)
+}
+
+// recordReader 带边界检查的读取器, 出错后的读取均返回零值
+type recordReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *recordReader) next(n int) []byte {
+	if r.err || n < 0 || len(r.b) < n {
+		r.err = true
+		return nil
+	}
+	v := r.b[:n:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *recordReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return goBinary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *recordReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return goBinary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *recordReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return goBinary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *recordReader) bytes() []byte {
+	return r.next(int(r.uint32()))
+}
+
+// decodeVideoRecord 解析并检查短视频记录, 同时支持旧格式
+func decodeVideoRecord(b []byte) (*videoRecord, error) {
+	if !bytes.HasPrefix(b, []byte(videoRecordMagic)) {
+		return decodeLegacyVideoRecord(b)
+	}
+	if len(b) < len(videoRecordMagic)+2+4 {
+		return nil, errInvalidVideoRecord
+	}
+	body, crc := b[:len(b)-4], goBinary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != crc {
+		return nil, errors.New("video record checksum mismatch")
+	}
+	r := &recordReader{b: body[len(videoRecordMagic):]}
+	v := &videoRecord{Version: r.uint16()}
+	if v.Version != videoRecordVersion {
+		return nil, errors.New("unsupported video record version")
+	}
+	v.Md5 = r.next(16)
+	v.ThumbMd5 = r.next(16)
+	v.Size = int64(r.uint64())
+	v.ThumbSize = int64(r.uint64())
+	v.Name = string(r.bytes())
+	v.UUID = r.bytes()
+	v.FileName = string(r.bytes())
+	v.MIME = string(r.bytes())
+	v.Source = string(r.bytes())
+	v.UploadedAt = int64(r.uint64())
+	if flag := r.next(1); flag != nil && flag[0] == 1 {
+		v.Encryption = &videoEncryption{
+			Algorithm: string(r.bytes()),
+			KeyID:     string(r.bytes()),
+			Nonce:     r.bytes(),
+		}
+	}
+	if r.err || len(r.b) != 0 || v.Size <= 0 || v.ThumbSize < 0 || len(v.UUID) == 0 {
+		return nil, errInvalidVideoRecord
+	}
+	return v, nil
+}
+
+// decodeLegacyVideoRecord 解析旧格式的短视频记录
+func decodeLegacyVideoRecord(b []byte) (*videoRecord, error) {
+	r := &recordReader{b: b}
+	v := &videoRecord{
+		Md5:       r.next(16),
+		ThumbMd5:  r.next(16),
+		Size:      int64(r.uint32()),
+		ThumbSize: int64(r.uint32()),
+	}
+	v.Name = string(r.next(int(r.uint32()) - 4))
+	v.UUID = r.b
+	if r.err || len(v.UUID) == 0 || v.Size <= 0 {
+		return nil, errInvalidVideoRecord
+	}
+	return v, nil
+}
+
+// videoRecordName 视频记录文件名
+func videoRecordName(md5 []byte) string {
+	return hex.EncodeToString(md5) + ".video"
+}
+
+// videoSourcePath 视频记录对应的本地源文件路径记录
+//
+// 源文件路径同时保存在记录之外, 记录损坏时仍可使用源文件重新上传
+func videoSourcePath(record string) string {
+	return strings.TrimSuffix(record, ".video") + ".source"
+}
+
+// readVideoSource 读取视频记录之外保存的本地源文件路径
+func readVideoSource(record string) string {
+	b, err := ioutil.ReadFile(videoSourcePath(record))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// detectMIME 获取文件的 MIME 类型
+func detectMIME(file string) string {
+	if f, err := os.Open(file); err == nil {
+		defer f.Close()
+		head := make([]byte, 512)
+		n, _ := f.Read(head)
+		if t := http.DetectContentType(head[:n]); t != "application/octet-stream" {
+			return t
+		}
+	}
+	if t := mime.TypeByExtension(filepath.Ext(file)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// newVideoRecord 根据上传结果生成短视频记录
+func newVideoRecord(gv *message.ShortVideoElement, source string) *videoRecord {
+	r := &videoRecord{
+		Version:    videoRecordVersion,
+		Md5:        gv.Md5,
+		ThumbMd5:   gv.ThumbMd5,
+		Size:       int64(uint32(gv.Size)),
+		ThumbSize:  int64(uint32(gv.ThumbSize)),
+		Name:       gv.Name,
+		UUID:       gv.Uuid,
+		UploadedAt: time.Now().Unix(),
+	}
+	if source != "" {
+		if abs, err := filepath.Abs(source); err == nil {
+			source = abs
+		}
+		r.Source = source
+		r.FileName = filepath.Base(source)
+		r.MIME = detectMIME(source)
+	}
+	return r
+}
+
+// writeVideoRecord 保存短视频记录, source 不为空时同时保存本地源文件信息以便失效后重新上传
+func writeVideoRecord(gv *message.ShortVideoElement, source string) (string, error) {
+	filename := videoRecordName(gv.Md5)
+	return filename, saveVideoRecord(path.Join(global.VideoPath, filename), newVideoRecord(gv, source))
+}
+
+// saveVideoRecord 写入短视频记录, 记录包含源文件路径时同时写入 .source 文件
+func saveVideoRecord(record string, r *videoRecord) error {
+	if r.Source != "" {
+		if err := ioutil.WriteFile(videoSourcePath(record), []byte(r.Source), 0644); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(record, encodeVideoRecord(r), 0644)
+}
+
+// readVideoRecord 读取短视频记录, 记录损坏但本地源文件仍存在时返回源文件以便重新上传
+func readVideoRecord(record string) (*LocalVideoElement, error) {
+	b, err := ioutil.ReadFile(record)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeVideoRecord(b)
+	if err != nil {
+		if source := readVideoSource(record); source != "" && global.PathExists(source) {
+			return &LocalVideoElement{File: source, record: record, source: source}, nil
+		}
+		return nil, err
+	}
+	if v.Version == 0 {
+		v.Source = readVideoSource(record)
+	}
+	return &LocalVideoElement{ShortVideoElement: v.element(), record: record, source: v.Source}, nil
+}
+
+// VideoRecordMigration 短视频记录迁移结果
+type VideoRecordMigration struct {
+	Total    int      `json:"total"`
+	Migrated int      `json:"migrated"`
+	Failed   []string `json:"failed"`
+}
+
+// MigrateVideoRecords 将 data/videos 中的旧格式短视频记录转换为最新格式
+func MigrateVideoRecords() (*VideoRecordMigration, error) {
+	return migrateVideoRecords(global.VideoPath)
+}
+
+func migrateVideoRecords(dir string) (*VideoRecordMigration, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.video"))
+	if err != nil {
+		return nil, err
+	}
+	m := &VideoRecordMigration{Total: len(files), Failed: []string{}}
+	for _, record := range files {
+		b, err := ioutil.ReadFile(record)
+		if err != nil {
+			m.Failed = append(m.Failed, filepath.Base(record))
+			continue
+		}
+		v, err := decodeVideoRecord(b)
+		if err != nil {
+			m.Failed = append(m.Failed, filepath.Base(record))
+			continue
+		}
+		if v.Version == videoRecordVersion {
+			continue
+		}
+		gv := v.element()
+		r := newVideoRecord(&gv, readVideoSource(record))
+		if info, err := os.Stat(record); err == nil {
+			r.UploadedAt = info.ModTime().Unix()
+		}
+		if err = saveVideoRecord(record, r); err != nil {
+			m.Failed = append(m.Failed, filepath.Base(record))
+			continue
+		}
+		m.Migrated++
+	}
+	return m, nil
+}
+
+// videoCheck 短视频记录的校验结果
+type videoCheck struct {
+	Revalidated int
+	Reuploaded  int
+}
+
+// resolveVideo 上传本地短视频或校验复用的短视频记录
+//
+// 启用 probe 时将向服务器确认记录是否仍然有效, 失效时使用保留的本地源文件重新上传
+func (bot *CQBot) resolveVideo(ctx context.Context, v *LocalVideoElement, check *videoCheck) (*message.ShortVideoElement, error) {
+	if v.record == "" {
+		return bot.UploadLocalVideo(v)
+	}
+	if v.File == "" {
+		if !bot.videoProbe {
+			return &v.ShortVideoElement, nil
+		}
+		if bot.Client.GetShortVideoUrl(v.Uuid, v.Md5) != "" {
+			check.Revalidated++
+			return &v.ShortVideoElement, nil
+		}
+		if v.source == "" || !global.PathExists(v.source) {
+			return nil, errors.New("视频记录已失效且本地源文件不存在")
+		}
+		Logger(ctx).Infof("视频记录 %v 已失效, 将使用本地源文件 %v 重新上传", filepath.Base(v.record), v.source)
+		v.File = v.source
+		v.thumb = bytes.NewReader(videoCover(v.source))
+	}
+	gv, err := bot.UploadLocalVideo(v)
+	if err != nil {
+		return nil, err
+	}
+	check.Reuploaded++
+	if _, err := writeVideoRecord(gv, v.source); err != nil {
+		Logger(ctx).Warnf("更新视频记录时出现错误: %v", err)
+	}
+	return gv, nil
+}
+
+// forwardRecordName 合并转发记录文件名
+func forwardRecordName(resID string) string {
+	hash := md5.Sum([]byte(resID))
+	return hex.EncodeToString(hash[:]) + ".forward"
+}
+
+// forwardRecord 合并转发记录, 分块文件的记录额外包含文件名、大小与MD5
+type forwardRecord struct {
+	ResID     string
+	NodeCount int
+	CreatedAt int64
+	Name      string
+	Size      int64
+	Md5       string
+}
+
+// writeForwardRecord 保存合并转发记录, 内容为 ResID、节点数与创建时间, 各占一行
+func writeForwardRecord(resID string, nodeCount int) error {
+	return global.WriteAllText(path.Join(global.VideoPath, forwardRecordName(resID)),
+		fmt.Sprintf("%s\n%d\n%d\n", resID, nodeCount, time.Now().Unix()))
+}
+
+// writeChunkedFileRecord 保存分块文件的合并转发记录, 在 writeForwardRecord 的内容后追加文件名、大小与MD5
+func writeChunkedFileRecord(resID string, m *ChunkManifest) error {
+	return global.WriteAllText(path.Join(global.VideoPath, forwardRecordName(resID)),
+		fmt.Sprintf("%s\n%d\n%d\n%s\n%d\n%s\n", resID, len(m.Parts)+1, time.Now().Unix(), m.Name, m.Size, m.Md5))
+}
+
+// readForwardRecordInfo 读取合并转发记录
+func readForwardRecordInfo(record string) (*forwardRecord, error) {
+	b, err := ioutil.ReadFile(record)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(b), "\n")
+	if len(lines) < 3 || lines[0] == "" {
+		return nil, errors.New("invalid forward record")
+	}
+	r := &forwardRecord{ResID: lines[0]}
+	r.NodeCount, _ = strconv.Atoi(lines[1])
+	r.CreatedAt, _ = strconv.ParseInt(lines[2], 10, 64)
+	if len(lines) >= 6 {
+		r.Name = lines[3]
+		r.Size, _ = strconv.ParseInt(lines[4], 10, 64)
+		r.Md5 = lines[5]
+	}
+	return r, nil
+}
+
+// readForwardRecord 读取合并转发记录中的 ResID
+func readForwardRecord(record string) (string, error) {
+	r, err := readForwardRecordInfo(record)
+	if err != nil {
+		return "", err
+	}
+	return r.ResID, nil
+}
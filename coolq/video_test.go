@@ -0,0 +1,100 @@
+package coolq
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sam01101/MiraiGo-qdrive/binary"
+)
+
+func TestVideoRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "videos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	md5 := bytes.Repeat([]byte{1}, 16)
+	legacy := binary.NewWriterF(func(w *binary.Writer) {
+		w.Write(md5)
+		w.Write(bytes.Repeat([]byte{2}, 16))
+		w.WriteUInt32(1024)
+		w.WriteUInt32(64)
+		w.WriteString("test.mp4")
+		w.Write([]byte("uuid"))
+	})
+	v, err := decodeVideoRecord(legacy)
+	if err != nil || v.Version != 0 || v.Size != 1024 || v.ThumbSize != 64 || v.Name != "test.mp4" || string(v.UUID) != "uuid" {
+		t.Fatalf("unexpected legacy record %+v: %v", v, err)
+	}
+	for _, n := range []int{0, 20, 44, len(legacy) - 4} {
+		if _, err := decodeVideoRecord(legacy[:n]); err == nil {
+			t.Fatalf("truncated record of %d bytes should be rejected", n)
+		}
+	}
+
+	r := &videoRecord{
+		Version: videoRecordVersion, Md5: md5, ThumbMd5: md5, Size: 5 << 30, ThumbSize: 64,
+		Name: "test.mp4", UUID: []byte("uuid"), FileName: "a.mp4", MIME: "video/mp4", Source: "/tmp/a.mp4",
+		UploadedAt: 1600000000, Encryption: &videoEncryption{Algorithm: "aes-256-gcm", KeyID: "k1", Nonce: []byte{1, 2}},
+	}
+	b := encodeVideoRecord(r)
+	v, err = decodeVideoRecord(b)
+	if err != nil || v.Size != 5<<30 || v.FileName != "a.mp4" || v.MIME != "video/mp4" || v.Source != "/tmp/a.mp4" ||
+		v.UploadedAt != 1600000000 || v.Encryption == nil || v.Encryption.KeyID != "k1" || string(v.UUID) != "uuid" {
+		t.Fatalf("unexpected record %+v: %v", v, err)
+	}
+	b[10] ^= 0xff
+	if _, err := decodeVideoRecord(b); err == nil {
+		t.Fatal("corrupted record should fail checksum")
+	}
+
+	record := filepath.Join(dir, videoRecordName(md5))
+	_ = ioutil.WriteFile(record, legacy[:30], 0644)
+	if _, err := readVideoRecord(record); err == nil {
+		t.Fatal("broken record without source should fail")
+	}
+	source := filepath.Join(dir, "source.mp4")
+	_ = ioutil.WriteFile(source, []byte("video"), 0644)
+	_ = ioutil.WriteFile(videoSourcePath(record), []byte(source), 0644)
+	e, err := readVideoRecord(record)
+	if err != nil || e.File != source || e.record != record {
+		t.Fatalf("broken record should fall back to source: %+v %v", e, err)
+	}
+
+	// 迁移旧格式记录
+	_ = ioutil.WriteFile(record, legacy, 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "broken.video"), []byte("xx"), 0644)
+	m, err := migrateVideoRecords(dir)
+	if err != nil || m.Total != 2 || m.Migrated != 1 || len(m.Failed) != 1 {
+		t.Fatalf("unexpected migration %+v: %v", m, err)
+	}
+	if e, err = readVideoRecord(record); err != nil || e.File != "" || e.Name != "test.mp4" || e.source != source {
+		t.Fatalf("unexpected element %+v: %v", e, err)
+	}
+	if b, _ := ioutil.ReadFile(record); !bytes.HasPrefix(b, []byte(videoRecordMagic)) {
+		t.Fatal("record should be rewritten in the current format")
+	}
+	if m, _ = migrateVideoRecords(dir); m.Migrated != 0 {
+		t.Fatalf("current records should not be migrated again: %+v", m)
+	}
+
+	// 当前格式的记录损坏时同样使用源文件重新上传
+	b, _ = ioutil.ReadFile(record)
+	b[len(b)/2] ^= 0xff
+	_ = ioutil.WriteFile(record, b, 0644)
+	if e, err = readVideoRecord(record); err != nil || e.File != source || e.source != source {
+		t.Fatalf("corrupted record should fall back to source: %+v %v", e, err)
+	}
+	fresh := filepath.Join(dir, videoRecordName(bytes.Repeat([]byte{3}, 16)))
+	r.Source = source
+	if err = saveVideoRecord(fresh, r); err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile(fresh, []byte(videoRecordMagic), 0644)
+	if e, err = readVideoRecord(fresh); err != nil || e.File != source {
+		t.Fatalf("truncated record should fall back to source: %+v %v", e, err)
+	}
+}
@@ -0,0 +1,214 @@
+package global
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CacheManager 管理缓存目录的大小
+//
+// 文件的修改时间视为最近使用时间, 超出配额时按最近使用时间从旧到新淘汰, 使用中的文件不会被淘汰
+type CacheManager struct {
+	Dir     string
+	MaxSize int64         // 缓存目录大小上限(字节), 0为不限制
+	MaxAge  time.Duration // 未使用的文件最长保留时间, 0为不限制
+
+	lock         sync.Mutex
+	inUse        map[string]int
+	evicted      int64
+	evictedBytes int64
+	lastClean    time.Time
+	stop         chan struct{}
+}
+
+// CacheStats 缓存目录统计
+type CacheStats struct {
+	Files        int   `json:"files"`
+	Bytes        int64 `json:"bytes"`
+	InUse        int   `json:"in_use"`
+	MaxSize      int64 `json:"max_size"`
+	MaxAge       int64 `json:"max_age"` // 秒
+	Evicted      int64 `json:"evicted"`
+	EvictedBytes int64 `json:"evicted_bytes"`
+	LastClean    int64 `json:"last_clean"` // Unix时间戳, 未清理过时为0
+}
+
+// CacheCleanResult 单次清理的结果
+type CacheCleanResult struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Cache 全局缓存目录管理器
+var Cache = &CacheManager{Dir: CachePath}
+
+func (m *CacheManager) key(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// managed 判断文件是否位于缓存目录中
+func (m *CacheManager) managed(k string) bool {
+	rel, err := filepath.Rel(m.key(m.Dir), k)
+	return err == nil && rel != "." && !strings.HasPrefix(rel, "..")
+}
+
+// Acquire 将文件标记为使用中并刷新其使用时间, 使用完毕后需调用返回的函数
+//
+// 不在缓存目录中的文件将被忽略
+func (m *CacheManager) Acquire(path string) (release func()) {
+	k := m.key(path)
+	if !m.managed(k) {
+		return func() {}
+	}
+	m.lock.Lock()
+	if m.inUse == nil {
+		m.inUse = map[string]int{}
+	}
+	m.inUse[k]++
+	m.lock.Unlock()
+	m.Touch(path)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.lock.Lock()
+			if m.inUse[k]--; m.inUse[k] <= 0 {
+				delete(m.inUse, k)
+			}
+			m.lock.Unlock()
+			m.Touch(path)
+		})
+	}
+}
+
+// Touch 刷新缓存文件的使用时间
+func (m *CacheManager) Touch(path string) {
+	if !m.managed(m.key(path)) {
+		return
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (m *CacheManager) files() ([]cacheFile, error) {
+	var files []cacheFile
+	err := filepath.Walk(m.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Stats 获取缓存目录统计
+func (m *CacheManager) Stats() (CacheStats, error) {
+	files, err := m.files()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := CacheStats{
+		Files:        len(files),
+		InUse:        len(m.inUse),
+		MaxSize:      m.MaxSize,
+		MaxAge:       int64(m.MaxAge / time.Second),
+		Evicted:      m.evicted,
+		EvictedBytes: m.evictedBytes,
+	}
+	if !m.lastClean.IsZero() {
+		s.LastClean = m.lastClean.Unix()
+	}
+	for _, f := range files {
+		s.Bytes += f.size
+	}
+	return s, err
+}
+
+// Clean 按配额淘汰缓存文件, all 为 true 时删除所有未使用的文件
+func (m *CacheManager) Clean(all bool) (CacheCleanResult, error) {
+	var r CacheCleanResult
+	files, err := m.files()
+	if err != nil {
+		return r, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cutoff := time.Now().Add(-m.MaxAge)
+	for _, f := range files {
+		if m.inUse[m.key(f.path)] > 0 {
+			continue
+		}
+		expired := m.MaxAge > 0 && f.modTime.Before(cutoff)
+		oversize := m.MaxSize > 0 && total > m.MaxSize
+		if !all && !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return r, err
+		}
+		total -= f.size
+		r.Files++
+		r.Bytes += f.size
+	}
+	m.evicted += int64(r.Files)
+	m.evictedBytes += r.Bytes
+	m.lastClean = time.Now()
+	return r, nil
+}
+
+// Start 每隔 interval 清理一次缓存, 重复调用将替换之前的定时任务
+func (m *CacheManager) Start(interval time.Duration) {
+	m.lock.Lock()
+	if m.stop != nil {
+		close(m.stop)
+	}
+	stop := make(chan struct{})
+	m.stop = stop
+	m.lock.Unlock()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			if r, err := m.Clean(false); err != nil {
+				log.Warnf("清理缓存目录时出现错误: %v", err)
+			} else if r.Files > 0 {
+				log.Debugf("已清理 %v 个缓存文件, 共 %v 字节", r.Files, r.Bytes)
+			}
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
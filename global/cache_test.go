@@ -0,0 +1,71 @@
+package global
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(p, now.Add(-age), now.Add(-age))
+		return p
+	}
+	oldest := write("a.cache", 100, time.Hour*3)
+	busy := write("b.cache", 100, time.Hour*2)
+	newest := write("c.cache", 100, time.Hour)
+
+	m := &CacheManager{Dir: dir, MaxSize: 150}
+	release := m.Acquire(busy)
+	// Acquire 会刷新使用时间
+	if info, _ := os.Stat(busy); now.Sub(info.ModTime()) > time.Minute {
+		t.Fatal("acquire should touch the file")
+	}
+	_ = os.Chtimes(busy, now.Add(-time.Hour*2), now.Add(-time.Hour*2))
+
+	r, err := m.Clean(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最旧的 a 被淘汰, b 使用中被跳过, 最终删除 c 使大小满足配额
+	if r.Files != 2 || PathExists(oldest) || !PathExists(busy) || PathExists(newest) {
+		t.Fatalf("unexpected clean result %+v", r)
+	}
+	release()
+
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 1 || stats.Bytes != 100 || stats.InUse != 0 || stats.Evicted != 2 || stats.EvictedBytes != 200 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if r, _ := m.Clean(true); r.Files != 1 || PathExists(busy) {
+		t.Fatalf("clean all should remove unused files: %+v", r)
+	}
+
+	// 缓存目录外的文件不受管理
+	outside, err := ioutil.TempFile("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside.Close()
+	defer os.Remove(outside.Name())
+	_ = os.Chtimes(outside.Name(), now.Add(-time.Hour), now.Add(-time.Hour))
+	m.Acquire(outside.Name())()
+	if info, _ := os.Stat(outside.Name()); now.Sub(info.ModTime()) < time.Minute {
+		t.Fatal("files outside the cache directory should not be touched")
+	}
+}
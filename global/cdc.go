@@ -0,0 +1,109 @@
+package global
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gearTable FastCDC 使用的 Gear 哈希表, 由固定种子生成, 修改后已上传文件的分块边界将全部改变
+var gearTable = func() (t [256]uint64) {
+	// splitmix64
+	x := uint64(0x6763712d71716472)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// Chunker 基于内容的分块器 (FastCDC)
+//
+// 分块边界由数据内容决定, 文件中部插入或删除数据时只会影响附近的分块
+type Chunker struct {
+	r            io.Reader
+	buf          []byte
+	start, end   int
+	eof          bool
+	min, avg     int
+	max          int
+	maskS, maskL uint64
+}
+
+// NewChunker 创建分块器, 分块大小介于 min 与 max 之间, 平均约为 avg
+func NewChunker(r io.Reader, min, avg, max int) *Chunker {
+	if avg < 64 {
+		avg = 64
+	}
+	if min <= 0 || min > avg {
+		min = avg / 4
+	}
+	if max < avg {
+		max = avg * 2
+	}
+	n := bits.Len(uint(avg)) - 1
+	return &Chunker{
+		r: r, buf: make([]byte, max), min: min, avg: avg, max: max,
+		// 使用哈希的高位判断边界, 平均长度之前使用更严格的掩码以使分块大小更集中
+		maskS: ^uint64(0) << (64 - n - 1),
+		maskL: ^uint64(0) << (64 - n + 1),
+	}
+}
+
+// Next 返回下一个分块, 数据读取完毕时返回 io.EOF
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.max && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	b := make([]byte, n)
+	copy(b, c.buf[c.start:])
+	c.start += n
+	return b, nil
+}
+
+// cut 返回 data 中第一个分块的长度
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+	normal := c.avg
+	if normal > n {
+		normal = n
+	}
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package global
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func chunkAll(t *testing.T, data []byte) [][]byte {
+	c := NewChunker(bytes.NewReader(data), 0, 1024, 0)
+	var chunks [][]byte
+	for {
+		b, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > 2048 {
+			t.Fatalf("chunk too large: %d", len(b))
+		}
+		chunks = append(chunks, b)
+	}
+}
+
+func TestChunker(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, 200*1024)
+	rnd.Read(data)
+	a := chunkAll(t, data)
+	if !bytes.Equal(bytes.Join(a, nil), data) {
+		t.Fatal("chunks do not reassemble to input")
+	}
+	if len(a) < 100 || len(a) > 400 {
+		t.Fatalf("unexpected chunk count %d", len(a))
+	}
+
+	// 在中部插入数据后, 大部分分块应保持不变
+	edited := append(append(append([]byte(nil), data[:100*1024]...), "inserted"...), data[100*1024:]...)
+	b := chunkAll(t, edited)
+	seen := map[string]bool{}
+	for _, c := range a {
+		seen[string(c)] = true
+	}
+	changed := 0
+	for _, c := range b {
+		if !seen[string(c)] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Fatalf("unexpected changed chunks: %d of %d", changed, len(b))
+	}
+}
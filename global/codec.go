@@ -9,9 +9,12 @@ import (
 func EncodeMP4(src string, dst string) error { //        -y 覆盖文件
 	cmd1 := exec.Command("ffmpeg", "-i", src, "-y", "-c", "copy", "-map", "0", dst)
 	err := cmd1.Run()
+	ffmpegInvocations.Inc("encode_mp4_copy", ResultLabel(err))
 	if err != nil {
 		cmd2 := exec.Command("ffmpeg", "-i", src, "-y", "-c:v", "h264", "-c:a", "mp3", dst)
-		return errors.Wrap(cmd2.Run(), "convert mp4 failed")
+		err = cmd2.Run()
+		ffmpegInvocations.Inc("encode_mp4", ResultLabel(err))
+		return errors.Wrap(err, "convert mp4 failed")
 	}
 	return err
 }
@@ -19,5 +22,7 @@ func EncodeMP4(src string, dst string) error { //        -y 覆盖文件
 // ExtractCover 获取给定视频文件的Cover
 func ExtractCover(src string, target string) error {
 	cmd := exec.Command("ffmpeg", "-i", src, "-y", "-r", "1", "-f", "image2", target)
-	return errors.Wrap(cmd.Run(), "extract video cover failed")
+	err := cmd.Run()
+	ffmpegInvocations.Inc("extract_cover", ResultLabel(err))
+	return errors.Wrap(err, "extract video cover failed")
 }
@@ -0,0 +1,121 @@
+package global
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 上传前压缩使用的算法
+const (
+	CompressionNone = "none"
+	CompressionAuto = "auto" // 根据 MIME 类型选择, 已压缩的格式不再压缩
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ErrUnsupportedCompression 不支持的压缩算法
+var ErrUnsupportedCompression = errors.New("unsupported compression")
+
+// compressors 可用的压缩算法
+var compressors = map[string]struct {
+	writer func(io.Writer) (io.WriteCloser, error)
+	reader func(io.Reader) (io.ReadCloser, error)
+}{
+	CompressionGzip: {
+		writer: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, gzip.BestSpeed) },
+		reader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	CompressionZstd: {
+		writer: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+}
+
+// NewCompressWriter 创建压缩写入器, 调用 Close 后数据才会完整写入 w
+func NewCompressWriter(alg string, w io.Writer) (io.WriteCloser, error) {
+	c, ok := compressors[alg]
+	if !ok {
+		return nil, ErrUnsupportedCompression
+	}
+	return c.writer(w)
+}
+
+// NewDecompressReader 创建解压读取器
+func NewDecompressReader(alg string, r io.Reader) (io.ReadCloser, error) {
+	c, ok := compressors[alg]
+	if !ok {
+		return nil, ErrUnsupportedCompression
+	}
+	return c.reader(r)
+}
+
+var (
+	// compressedMIMEs 已压缩的 MIME 类型
+	compressedMIMEs = []string{
+		"application/zip", "application/x-gzip", "application/gzip", "application/x-rar-compressed",
+		"application/x-7z-compressed", "application/x-xz", "application/zstd", "application/x-bzip2",
+		"application/pdf", "font/woff", "font/woff2",
+	}
+	// compressedExts 已压缩格式的扩展名, 用于 MIME 无法识别的情况
+	compressedExts = []string{
+		".zip", ".gz", ".tgz", ".7z", ".rar", ".xz", ".zst", ".bz2", ".br", ".lz4",
+		".docx", ".xlsx", ".pptx", ".apk", ".jar", ".mkv", ".flac", ".webm",
+	}
+)
+
+// IsCompressedFormat 根据文件头与文件名判断是否为已压缩的格式
+func IsCompressedFormat(head []byte, name string) bool {
+	types := []string{http.DetectContentType(head), mime.TypeByExtension(filepath.Ext(name))}
+	for _, t := range types {
+		t = strings.TrimSpace(strings.SplitN(t, ";", 2)[0])
+		switch {
+		case t == "image/svg+xml" || t == "image/bmp" || t == "audio/wave":
+		case strings.HasPrefix(t, "image/") || strings.HasPrefix(t, "video/") || strings.HasPrefix(t, "audio/"):
+			return true
+		}
+		for _, c := range compressedMIMEs {
+			if t == c {
+				return true
+			}
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range compressedExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ChooseCompression 根据请求的算法与文件内容选择实际使用的压缩算法, 不压缩时返回空值
+func ChooseCompression(requested string, head []byte, name string) (string, error) {
+	switch requested {
+	case "", CompressionNone:
+		return "", nil
+	case CompressionAuto:
+		requested = CompressionGzip
+	}
+	if _, ok := compressors[requested]; !ok {
+		return "", ErrUnsupportedCompression
+	}
+	if IsCompressedFormat(head, name) {
+		return "", nil
+	}
+	return requested, nil
+}
@@ -0,0 +1,65 @@
+package global
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestChooseCompression(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	text := []byte("hello world hello world")
+	cases := []struct {
+		requested string
+		head      []byte
+		name      string
+		want      string
+	}{
+		{"", text, "a.txt", ""},
+		{CompressionNone, text, "a.txt", ""},
+		{CompressionGzip, text, "a.txt", CompressionGzip},
+		{CompressionZstd, text, "a.txt", CompressionZstd},
+		{CompressionZstd, png, "a.bin", ""},
+		{CompressionAuto, text, "a.log", CompressionGzip},
+		{CompressionAuto, png, "a.bin", ""},
+		{CompressionGzip, text, "a.zip", ""},
+		{CompressionAuto, text, "a.mp4", ""},
+	}
+	for _, c := range cases {
+		got, err := ChooseCompression(c.requested, c.head, c.name)
+		if err != nil || got != c.want {
+			t.Errorf("ChooseCompression(%q, %q) = %q, %v, want %q", c.requested, c.name, got, err, c.want)
+		}
+	}
+	if _, err := ChooseCompression("lz4", text, "a.txt"); !errors.Is(err, ErrUnsupportedCompression) {
+		t.Fatalf("lz4 should be unsupported, got %v", err)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("go-cqhttp "), 1000)
+	for _, alg := range []string{CompressionGzip, CompressionZstd} {
+		var buf bytes.Buffer
+		w, err := NewCompressWriter(alg, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(data)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() >= len(data) {
+			t.Fatalf("%v: compressed size %d >= %d", alg, buf.Len(), len(data))
+		}
+		r, err := NewDecompressReader(alg, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		_ = r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%v: round trip mismatch: %v", alg, err)
+		}
+	}
+}
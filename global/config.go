@@ -29,6 +29,33 @@ var DefaultConfigWithComments = `
     enable_db: true
     // 访问密钥, 强烈推荐在公网的服务器设置
     access_token: ""
+    // 请求签名设置
+    // 启用后客户端可使用 HMAC 签名代替 access_token 进行鉴权
+    request_sign: {
+        // 是否启用请求签名
+        enabled: false
+        // 签名密钥
+        secret: ""
+        // 时间戳允许的误差, 单位秒, 同时也是 nonce 的缓存时间
+        window: 300
+    }
+    // 附加的API令牌列表
+    // 每个令牌可单独限制允许调用的API, 过期时间与来源IP
+    tokens: [
+        // {
+        //     // 令牌名称, 仅用于日志
+        //     name: readonly
+        //     // 令牌
+        //     token: ""
+        //     // 允许调用的API, 支持通配符, 如 "*" 或 "get_*"
+        //     // Admin API 需以 "admin/" 开头, 如 "admin/*"
+        //     actions: ["get_forward_msg", "get_login_info"]
+        //     // 过期时间, Unix时间戳(秒), 0为永不过期
+        //     expire_at: 0
+        //     // 允许的来源IP或网段, 留空为不限制
+        //     allow_ips: ["127.0.0.1", "10.0.0.0/8"]
+        // }
+    ]
     // 重连设置
     relogin: {
         // 是否启用自动重连
@@ -62,11 +89,23 @@ var DefaultConfigWithComments = `
     // 心跳频率, 单位秒
     // -1 为关闭心跳
     heartbeat_interval: 0
+    // 事件队列设置
+    // 每个上报端点(HTTP POST, WS连接等)拥有独立的队列, 事件按顺序上报
+    event_queue: {
+        // 队列长度
+        size: 256
+        // 队列已满时的策略
+        // block: 等待队列空出, 可能拖慢其他端点的上报
+        // drop_oldest: 丢弃最旧的事件
+        // drop_newest: 丢弃新的事件
+        overflow: drop_oldest
+    }
     // HTTP设置
     http_config: {
         // 是否启用正向HTTP服务器
         enabled: true
         // 服务端监听地址
+        // 可使用 unix:///path/to.sock 监听 unix socket, 此时将忽略端口设置
         host: 0.0.0.0
         // 服务端监听端口
         port: 5700
@@ -74,20 +113,37 @@ var DefaultConfigWithComments = `
         // 最小值为5，小于5将会忽略本项设置
         timeout: 0
         // 反向HTTP POST地址列表
+        // 可使用 unix:///path/to.sock:/path 连接 unix socket
         // 格式: 
         // {
         //    地址: secret
         // }
         post_urls: {}
+        // TLS证书与私钥路径, 留空为不启用HTTPS
+        // 证书文件更新后将自动重新加载
+        tls_cert: ""
+        tls_key: ""
+        // 客户端CA证书路径, 设置后将要求客户端提供证书(mTLS)
+        tls_client_ca: ""
+        // unix socket 文件权限(八进制), 仅在监听地址为 unix:// 时生效
+        unix_socket_mode: "0660"
     }
     // 正向WS设置
     ws_config: {
         // 是否启用正向WS服务器
         enabled: true
         // 正向WS服务器监听地址
+        // 可使用 unix:///path/to.sock 监听 unix socket, 此时将忽略端口设置
         host: 0.0.0.0
         // 正向WS服务器监听端口
         port: 6700
+        // TLS证书与私钥路径, 留空为不启用WSS
+        tls_cert: ""
+        tls_key: ""
+        // 客户端CA证书路径, 设置后将要求客户端提供证书(mTLS)
+        tls_client_ca: ""
+        // unix socket 文件权限(八进制), 仅在监听地址为 unix:// 时生效
+        unix_socket_mode: "0660"
     }
     // 反向WS设置
     ws_reverse_servers: [
@@ -96,6 +152,7 @@ var DefaultConfigWithComments = `
             // 是否启用该推送
             enabled: false
             // 反向WS Universal 地址
+            // 可使用 unix:///path/to.sock:/path 连接 unix socket
             // 注意 设置了此项地址后下面两项将会被忽略
             // 留空请使用 ""
             reverse_url: ws://you_websocket_universal.server
@@ -105,6 +162,8 @@ var DefaultConfigWithComments = `
             reverse_event_url: ws://you_websocket_event.server
             // 重连间隔 单位毫秒
             reverse_reconnect_interval: 3000
+            // 连接 wss:// 地址时额外信任的CA证书路径, 留空为仅使用系统根证书
+            tls_ca: ""
         }
     ]
     // 上报数据类型
@@ -117,16 +176,107 @@ var DefaultConfigWithComments = `
     debug: false
     // 日志等级 trace,debug,info,warn,error
     log_level: "info"
+    // 日志格式 text,json
+    // json 格式下控制台与日志文件均输出结构化日志, API调用日志将附带 request_id 等字段
+    log_format: "text"
+    // 分块上传设置
+    chunked_file: {
+        // 分块大小, 单位MB
+        part_size: 32
+        // 每个条带的数据分块数
+        data_shards: 4
+        // 每个条带附加的 Reed-Solomon 校验分块数, 0为不生成
+        // 每个条带最多可丢失 parity_shards 个分块
+        parity_shards: 0
+        // 压缩算法: none, gzip, zstd, auto
+        // auto 将根据文件类型选择, 已压缩的格式(图片, 视频, 压缩包等)不会再次压缩
+        compression: "none"
+        // 分块方式: fixed 为固定大小分块, cdc 为基于内容分块
+        // cdc 的分块大小介于 part_size 的 1/4 与 2 倍之间, 文件局部修改后重新上传时大部分分块可直接复用
+        // 启用压缩时压缩后的数据会整体改变, 将无法复用分块
+        chunking: "fixed"
+    }
+    // backup 子命令设置
+    backup: {
+        // 需要备份的目录
+        dirs: []
+        // 每次备份后按以下规则清理快照, 均为0时保留全部快照
+        // 保留最近的快照数
+        keep_last: 1
+        // 保留最近几天每天最新的一个快照
+        keep_daily: 7
+        // 保留最近几周每周最新的一个快照
+        keep_weekly: 4
+        // 保留最近几个月每月最新的一个快照
+        keep_monthly: 6
+    }
+    // 短视频记录 data/videos 设置
+    video_record: {
+        // 复用短视频记录前是否向服务器确认其仍然有效
+        // 失效时将使用上传时保留的本地源文件重新上传
+        probe: false
+    }
+    // 定期检查 data/videos 中记录的视频与合并转发消息是否仍然可用
+    // 发现问题时将上报 health_check 通知
+    health_check: {
+        // 检查间隔, 单位分钟, 0为关闭
+        interval: 0
+        // 同时检查的记录数
+        concurrency: 4
+        // 大于0时下载视频的前 range_fetch 字节确认可用, 单位字节
+        range_fetch: 0
+    }
+    // 缓存目录 data/cache 设置
+    cache: {
+        // 缓存目录大小上限, 单位MB, 超出时淘汰最久未使用的文件, 0为不限制
+        max_size: 0
+        // 未使用的缓存文件保留时间, 单位小时, 0为不限制
+        max_age: 0
+        // 自动清理间隔, 单位分钟, 0为关闭自动清理
+        clean_interval: 10
+    }
+    // 日志文件保留设置
+    log_retention: {
+        // 日志保留天数, 0为不限制
+        max_age: 7
+        // 日志目录总大小上限, 单位MB, 0为不限制
+        max_total_size: 0
+        // 是否使用gzip压缩已轮转的日志
+        compress: false
+        // 是否将 error 及以上等级的日志额外写入 logs/日期.error.log
+        error_file: false
+    }
+    // 登录验证(滑块、验证码、短信、设备锁)的处理方式
+    login_challenge: {
+        // console: 控制台输入
+        // web: 通过 Admin API 的 do_web_write 输入
+        // webhook: 将验证信息 POST 到 webhook_url, 使用响应中的 answer 或等待 do_web_write 输入
+        // file: 将验证信息写入 dir/challenge.json, 等待 dir/answer.txt
+        // 留空时 web_ui.web_input 开启则为 web, 否则为 console
+        provider: ""
+        webhook_url: ""
+        dir: "data/challenge"
+        // 等待输入的超时时间, 单位秒, 0为不限制, 对 console 无效
+        timeout: 0
+    }
     // WebUi 设置
     web_ui: {
         // 是否启用 WebUi
         enabled: true
         // 监听地址
+        // 可使用 unix:///path/to.sock 监听 unix socket, 此时将忽略端口设置
         host: 127.0.0.1
         // 监听端口
         web_ui_port: 9999
         // 是否接收来自web的输入
         web_input: false
+        // TLS证书与私钥路径, 留空为不启用HTTPS
+        tls_cert: ""
+        tls_key: ""
+        // 客户端CA证书路径, 设置后将要求客户端提供证书(mTLS)
+        tls_client_ca: ""
+        // unix socket 文件权限(八进制), 仅在监听地址为 unix:// 时生效
+        unix_socket_mode: "0660"
     }
 }
 `
@@ -136,13 +286,15 @@ var PasswordHash [16]byte
 
 // JSONConfig Config对应的结构体
 type JSONConfig struct {
-	Uin               int64  `json:"uin"`
-	Password          string `json:"password"`
-	EncryptPassword   bool   `json:"encrypt_password"`
-	PasswordEncrypted string `json:"password_encrypted"`
-	EnableDB          bool   `json:"enable_db"`
-	EnableSelfMessage bool   `json:"enable_self_message"`
-	AccessToken       string `json:"access_token"`
+	Uin               int64                  `json:"uin"`
+	Password          string                 `json:"password"`
+	EncryptPassword   bool                   `json:"encrypt_password"`
+	PasswordEncrypted string                 `json:"password_encrypted"`
+	EnableDB          bool                   `json:"enable_db"`
+	EnableSelfMessage bool                   `json:"enable_self_message"`
+	AccessToken       string                 `json:"access_token"`
+	RequestSign       *GoCQRequestSignConfig `json:"request_sign"`
+	Tokens            []*GoCQAPIToken        `json:"tokens"`
 	ReLogin           struct {
 		Enabled         bool `json:"enabled"`
 		ReLoginDelay    int  `json:"relogin_delay"`
@@ -158,6 +310,7 @@ type JSONConfig struct {
 	FixURL              bool                          `json:"fix_url"`
 	ProxyRewrite        string                        `json:"proxy_rewrite"`
 	HeartbeatInterval   time.Duration                 `json:"heartbeat_interval"`
+	EventQueue          *GoCQEventQueueConfig         `json:"event_queue"`
 	HTTPConfig          *GoCQHTTPConfig               `json:"http_config"`
 	WSConfig            *GoCQWebSocketConfig          `json:"ws_config"`
 	ReverseServers      []*GoCQReverseWebSocketConfig `json:"ws_reverse_servers"`
@@ -165,6 +318,14 @@ type JSONConfig struct {
 	UseSSOAddress       bool                          `json:"use_sso_address"`
 	Debug               bool                          `json:"debug"`
 	LogLevel            string                        `json:"log_level"`
+	LogFormat           string                        `json:"log_format"`
+	LogRetention        *GoCQLogRetentionConfig       `json:"log_retention"`
+	Cache               *GoCQCacheConfig              `json:"cache"`
+	ChunkedFile         *GoCQChunkedFileConfig        `json:"chunked_file"`
+	Backup              *GoCQBackupConfig             `json:"backup"`
+	VideoRecord         *GoCQVideoRecordConfig        `json:"video_record"`
+	HealthCheck         *GoCQHealthCheckConfig        `json:"health_check"`
+	LoginChallenge      *GoCQLoginChallengeConfig     `json:"login_challenge"`
 	WebUI               *GoCQWebUI                    `json:"web_ui"`
 }
 
@@ -190,18 +351,26 @@ type CQHTTPAPIConfig struct {
 
 // GoCQHTTPConfig 正向HTTP对应config结构体
 type GoCQHTTPConfig struct {
-	Enabled  bool              `json:"enabled"`
-	Host     string            `json:"host"`
-	Port     uint16            `json:"port"`
-	Timeout  int32             `json:"timeout"`
-	PostUrls map[string]string `json:"post_urls"`
+	Enabled        bool              `json:"enabled"`
+	Host           string            `json:"host"`
+	Port           uint16            `json:"port"`
+	Timeout        int32             `json:"timeout"`
+	PostUrls       map[string]string `json:"post_urls"`
+	TLSCert        string            `json:"tls_cert"`
+	TLSKey         string            `json:"tls_key"`
+	TLSClientCA    string            `json:"tls_client_ca"`
+	UnixSocketMode string            `json:"unix_socket_mode"`
 }
 
 // GoCQWebSocketConfig 正向WebSocket对应Config结构体
 type GoCQWebSocketConfig struct {
-	Enabled bool   `json:"enabled"`
-	Host    string `json:"host"`
-	Port    uint16 `json:"port"`
+	Enabled        bool   `json:"enabled"`
+	Host           string `json:"host"`
+	Port           uint16 `json:"port"`
+	TLSCert        string `json:"tls_cert"`
+	TLSKey         string `json:"tls_key"`
+	TLSClientCA    string `json:"tls_client_ca"`
+	UnixSocketMode string `json:"unix_socket_mode"`
 }
 
 // GoCQReverseWebSocketConfig 反向WebSocket对应Config结构体
@@ -211,14 +380,94 @@ type GoCQReverseWebSocketConfig struct {
 	ReverseAPIURL            string `json:"reverse_api_url"`
 	ReverseEventURL          string `json:"reverse_event_url"`
 	ReverseReconnectInterval uint16 `json:"reverse_reconnect_interval"`
+	TLSCA                    string `json:"tls_ca"`
+}
+
+// GoCQRequestSignConfig 请求签名对应Config结构体
+type GoCQRequestSignConfig struct {
+	Enabled bool   `json:"enabled"`
+	Secret  string `json:"secret"`
+	Window  int64  `json:"window"`
+}
+
+// GoCQAPIToken 附加API令牌对应Config结构体
+type GoCQAPIToken struct {
+	Name     string   `json:"name"`
+	Token    string   `json:"token"`
+	Actions  []string `json:"actions"`
+	ExpireAt int64    `json:"expire_at"`
+	AllowIPs []string `json:"allow_ips"`
+}
+
+// GoCQEventQueueConfig 事件队列对应Config结构体
+type GoCQEventQueueConfig struct {
+	Size     int    `json:"size"`
+	Overflow string `json:"overflow"`
+}
+
+// GoCQLogRetentionConfig 日志保留对应Config结构体
+type GoCQLogRetentionConfig struct {
+	MaxAge       int   `json:"max_age"`
+	MaxTotalSize int64 `json:"max_total_size"`
+	Compress     bool  `json:"compress"`
+	ErrorFile    bool  `json:"error_file"`
+}
+
+// GoCQCacheConfig 缓存目录对应Config结构体
+type GoCQCacheConfig struct {
+	MaxSize       int64 `json:"max_size"`
+	MaxAge        int64 `json:"max_age"`
+	CleanInterval int64 `json:"clean_interval"`
+}
+
+// GoCQChunkedFileConfig 分块上传对应Config结构体
+type GoCQChunkedFileConfig struct {
+	PartSize     int64  `json:"part_size"`
+	DataShards   int    `json:"data_shards"`
+	ParityShards int    `json:"parity_shards"`
+	Compression  string `json:"compression"`
+	Chunking     string `json:"chunking"`
+}
+
+// GoCQBackupConfig 备份对应Config结构体
+type GoCQBackupConfig struct {
+	Dirs        []string `json:"dirs"`
+	KeepLast    int      `json:"keep_last"`
+	KeepDaily   int      `json:"keep_daily"`
+	KeepWeekly  int      `json:"keep_weekly"`
+	KeepMonthly int      `json:"keep_monthly"`
+}
+
+// GoCQVideoRecordConfig 短视频记录对应Config结构体
+type GoCQVideoRecordConfig struct {
+	Probe bool `json:"probe"`
+}
+
+// GoCQHealthCheckConfig 健康检查对应Config结构体
+type GoCQHealthCheckConfig struct {
+	Interval    int64 `json:"interval"`
+	Concurrency int   `json:"concurrency"`
+	RangeFetch  int64 `json:"range_fetch"`
+}
+
+// GoCQLoginChallengeConfig 登录验证对应Config结构体
+type GoCQLoginChallengeConfig struct {
+	Provider   string `json:"provider"`
+	WebhookURL string `json:"webhook_url"`
+	Dir        string `json:"dir"`
+	Timeout    int64  `json:"timeout"`
 }
 
 // GoCQWebUI WebUI对应Config结构体
 type GoCQWebUI struct {
-	Enabled   bool   `json:"enabled"`
-	Host      string `json:"host"`
-	WebUIPort uint64 `json:"web_ui_port"`
-	WebInput  bool   `json:"web_input"`
+	Enabled        bool   `json:"enabled"`
+	Host           string `json:"host"`
+	WebUIPort      uint64 `json:"web_ui_port"`
+	WebInput       bool   `json:"web_input"`
+	TLSCert        string `json:"tls_cert"`
+	TLSKey         string `json:"tls_key"`
+	TLSClientCA    string `json:"tls_client_ca"`
+	UnixSocketMode string `json:"unix_socket_mode"`
 }
 
 // DefaultConfig 返回一份默认配置对应结构体
@@ -243,6 +492,40 @@ func DefaultConfig() *JSONConfig {
 			Frequency:  1,
 			BucketSize: 1,
 		},
+		RequestSign: &GoCQRequestSignConfig{
+			Enabled: false,
+			Window:  300,
+		},
+		EventQueue: &GoCQEventQueueConfig{
+			Size:     256,
+			Overflow: "drop_oldest",
+		},
+		LogRetention: &GoCQLogRetentionConfig{
+			MaxAge: 7,
+		},
+		Cache: &GoCQCacheConfig{
+			CleanInterval: 10,
+		},
+		ChunkedFile: &GoCQChunkedFileConfig{
+			PartSize:    32,
+			DataShards:  4,
+			Compression: CompressionNone,
+			Chunking:    "fixed",
+		},
+		Backup: &GoCQBackupConfig{
+			Dirs:        []string{},
+			KeepLast:    1,
+			KeepDaily:   7,
+			KeepWeekly:  4,
+			KeepMonthly: 6,
+		},
+		VideoRecord: &GoCQVideoRecordConfig{},
+		LoginChallenge: &GoCQLoginChallengeConfig{
+			Dir: "data/challenge",
+		},
+		HealthCheck: &GoCQHealthCheckConfig{
+			Concurrency: 4,
+		},
 		PostMessageFormat: "string",
 		ForceFragmented:   false,
 		HTTPConfig: &GoCQHTTPConfig{
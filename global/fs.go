@@ -24,6 +24,8 @@ const (
 	VideoPath = "data/videos"
 	// CachePath go-cqhttp使用的缓存目录
 	CachePath = "data/cache"
+	// BackupPath backup 子命令使用的快照索引目录
+	BackupPath = "data/backups"
 )
 
 // PathExists 判断给定path是否存在
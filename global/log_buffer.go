@@ -0,0 +1,145 @@
+package global
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogEntry 内存中保存的一条日志
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LogFilter 日志过滤条件, 零值字段不参与过滤
+type LogFilter struct {
+	Level   logrus.Level // 最低日志等级, 为0(panic)时不过滤
+	Since   time.Time
+	Until   time.Time
+	Keyword string // 消息或字段中包含的子串
+	Limit   int    // 最多返回最近的条数
+}
+
+// Match 判断日志是否满足过滤条件
+func (f *LogFilter) Match(e *LogEntry) bool {
+	if f.Level != logrus.PanicLevel {
+		if lvl, err := logrus.ParseLevel(e.Level); err == nil && lvl > f.Level {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if f.Keyword != "" && !strings.Contains(e.Message, f.Keyword) {
+		for _, v := range e.Fields {
+			if s, ok := v.(string); ok && strings.Contains(s, f.Keyword) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// LogBuffer 在内存中保留最近日志的 logrus 钩子, 并支持实时订阅
+type LogBuffer struct {
+	lock    sync.RWMutex
+	entries []LogEntry
+	next    int
+	full    bool
+	subs    map[chan LogEntry]struct{}
+}
+
+// LogRing 全局日志缓冲区, 由 main 注册为 logrus 钩子
+var LogRing = NewLogBuffer(2000)
+
+// NewLogBuffer 创建保留最近 size 条日志的缓冲区
+func NewLogBuffer(size int) *LogBuffer {
+	if size <= 0 {
+		size = 2000
+	}
+	return &LogBuffer{entries: make([]LogEntry, size), subs: map[chan LogEntry]struct{}{}}
+}
+
+// Levels ref: logrus/hooks.go impl Hook interface
+func (b *LogBuffer) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire ref: logrus/hooks.go impl Hook interface
+func (b *LogBuffer) Fire(entry *logrus.Entry) error {
+	e := LogEntry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: strings.TrimSpace(entry.Message),
+	}
+	if len(entry.Data) > 0 {
+		e.Fields = make(map[string]interface{}, len(entry.Data))
+		for k, v := range entry.Data {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			e.Fields[k] = v
+		}
+	}
+	b.lock.Lock()
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default: // 订阅者处理过慢时丢弃
+		}
+	}
+	b.lock.Unlock()
+	return nil
+}
+
+// Query 按时间顺序返回满足条件的日志
+func (b *LogBuffer) Query(f *LogFilter) []LogEntry {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	var all []LogEntry
+	if b.full {
+		all = append(all, b.entries[b.next:]...)
+	}
+	all = append(all, b.entries[:b.next]...)
+	r := make([]LogEntry, 0)
+	for i := range all {
+		if f.Match(&all[i]) {
+			r = append(r, all[i])
+		}
+	}
+	if f.Limit > 0 && len(r) > f.Limit {
+		r = r[len(r)-f.Limit:]
+	}
+	return r
+}
+
+// Subscribe 订阅新产生的日志, 调用返回的函数取消订阅
+func (b *LogBuffer) Subscribe() (<-chan LogEntry, func()) {
+	ch := make(chan LogEntry, 256)
+	b.lock.Lock()
+	b.subs[ch] = struct{}{}
+	b.lock.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subs, ch)
+			b.lock.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package global
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogBuffer(t *testing.T) {
+	b := NewLogBuffer(3)
+	ch, cancel := b.Subscribe()
+	defer cancel()
+	now := time.Now()
+	for i, lv := range []logrus.Level{logrus.InfoLevel, logrus.WarnLevel, logrus.DebugLevel, logrus.ErrorLevel} {
+		_ = b.Fire(&logrus.Entry{
+			Time:    now.Add(time.Duration(i) * time.Second),
+			Level:   lv,
+			Message: lv.String() + " message\n",
+			Data:    logrus.Fields{"action": "get_status"},
+		})
+	}
+	if r := b.Query(&LogFilter{}); len(r) != 3 || r[0].Level != "warning" || r[2].Level != "error" {
+		t.Fatalf("unexpected ring content: %+v", r)
+	}
+	if r := b.Query(&LogFilter{Level: logrus.WarnLevel}); len(r) != 2 {
+		t.Fatalf("level filter: got %d entries", len(r))
+	}
+	if r := b.Query(&LogFilter{Since: now.Add(2 * time.Second)}); len(r) != 2 {
+		t.Fatalf("since filter: got %d entries", len(r))
+	}
+	if r := b.Query(&LogFilter{Keyword: "get_status", Limit: 1}); len(r) != 1 || r[0].Level != "error" {
+		t.Fatalf("keyword/limit filter: %+v", r)
+	}
+	if e := <-ch; e.Message != "info message" {
+		t.Fatalf("unexpected subscribed entry: %+v", e)
+	}
+}
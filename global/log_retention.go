@@ -0,0 +1,189 @@
+package global
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	"github.com/sirupsen/logrus"
+)
+
+// LogRetention 日志文件的轮转与保留策略
+//
+// 由 NewWriter 创建的日志文件在每次轮转后清理, 当前正在写入的文件不会被压缩或删除
+type LogRetention struct {
+	Dir          string        // 日志目录
+	MaxAge       time.Duration // 最长保留时间, 0为不限制
+	MaxTotalSize int64         // 日志目录总大小上限(字节), 0为不限制
+	Compress     bool          // 是否使用 gzip 压缩已轮转的日志
+
+	lock    sync.Mutex
+	writers []*rotatelogs.RotateLogs
+}
+
+// NewWriter 创建按天轮转的日志写入器, 文件名为 日期+suffix, 如 2006-01-02.error.log
+func (r *LogRetention) NewWriter(suffix string) (*rotatelogs.RotateLogs, error) {
+	w, err := rotatelogs.New(filepath.Join(r.Dir, "%Y-%m-%d"+suffix),
+		rotatelogs.WithRotationTime(time.Hour*24),
+		// 清理由 LogRetention 负责, 关闭 rotatelogs 自带的按时间删除
+		rotatelogs.WithRotationCount(^uint(0)),
+		rotatelogs.WithHandler(rotatelogs.HandlerFunc(func(e rotatelogs.Event) {
+			if e.Type() == rotatelogs.FileRotatedEventType {
+				// 回调时 rotatelogs 持有写锁, 需异步清理
+				go r.clean()
+			}
+		})),
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	r.writers = append(r.writers, w)
+	r.lock.Unlock()
+	return w, nil
+}
+
+func (r *LogRetention) clean() {
+	if err := r.Clean(); err != nil {
+		logrus.Warnf("清理日志文件时出现错误: %v", err)
+	}
+}
+
+// Clean 压缩已轮转的日志并删除超出保留策略的文件
+func (r *LogRetention) Clean() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	active := map[string]bool{}
+	for _, w := range r.writers {
+		if name := w.CurrentFileName(); name != "" {
+			active[filepath.Clean(name)] = true
+		}
+	}
+	files, err := r.listFiles()
+	if err != nil {
+		return err
+	}
+	if r.Compress {
+		for i, f := range files {
+			if active[f.path] || !strings.HasSuffix(f.path, ".log") {
+				continue
+			}
+			gz, err := gzipFile(f.path)
+			if err != nil {
+				return err
+			}
+			files[i] = gz
+		}
+	}
+	// 从旧到新删除
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	cutoff := time.Now().Add(-r.MaxAge)
+	for _, f := range files {
+		if active[f.path] {
+			continue
+		}
+		expired := r.MaxAge > 0 && f.modTime.Before(cutoff)
+		oversize := r.MaxTotalSize > 0 && total > r.MaxTotalSize
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+type logFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (r *LogRetention) listFiles() ([]logFile, error) {
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []logFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !(strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, logFile{path: filepath.Join(r.Dir, name), size: info.Size(), modTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// gzipFile 将 path 压缩为 path.gz 并删除原文件, 保留原修改时间
+//
+// path.gz 已存在时追加为新的 gzip member, 解压时将按顺序拼接
+func gzipFile(path string) (logFile, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return logFile{}, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return logFile{}, err
+	}
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return logFile{}, err
+	}
+	var offset int64
+	if fi, err := dst.Stat(); err == nil {
+		offset = fi.Size()
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if offset == 0 {
+			_ = os.Remove(path + ".gz")
+		} else {
+			_ = os.Truncate(path+".gz", offset)
+		}
+		return logFile{}, err
+	}
+	_ = src.Close()
+	if err = os.Remove(path); err != nil {
+		return logFile{}, err
+	}
+	_ = os.Chtimes(path+".gz", info.ModTime(), info.ModTime())
+	gz, err := os.Stat(path + ".gz")
+	if err != nil {
+		return logFile{}, err
+	}
+	return logFile{path: path + ".gz", size: gz.Size(), modTime: info.ModTime()}, nil
+}
+
+// ErrorLevels 单独写入错误日志文件的等级
+var ErrorLevels = []logrus.Level{logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel}
@@ -0,0 +1,75 @@
+package global
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	write := func(name, content string, age time.Duration) {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(p, now.Add(-age), now.Add(-age))
+	}
+	write("2000-01-01.log", "expired", time.Hour*24*30)
+	write("2000-01-02.log", strings.Repeat("a", 4096), time.Hour*24*2)
+	write("2000-01-03.log", strings.Repeat("b", 4096), time.Hour*24)
+	write("2000-01-03.error.log", "error", time.Hour*24)
+	write("readme.txt", "keep", time.Hour*24*30)
+
+	r := &LogRetention{Dir: dir, MaxAge: time.Hour * 24 * 7, Compress: true}
+	if err := r.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"2000-01-02.log.gz", "2000-01-03.log.gz", "2000-01-03.error.log.gz", "readme.txt"} {
+		if !PathExists(filepath.Join(dir, name)) {
+			t.Fatalf("%v should exist", name)
+		}
+	}
+	for _, name := range []string{"2000-01-01.log", "2000-01-02.log"} {
+		if PathExists(filepath.Join(dir, name)) {
+			t.Fatalf("%v should be removed", name)
+		}
+	}
+
+	// 同名日志再次压缩时追加
+	write("2000-01-03.log", "more", time.Hour*24)
+	if err := r.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "2000-01-03.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(zr)
+	f.Close()
+	if string(b) != strings.Repeat("b", 4096)+"more" {
+		t.Fatalf("unexpected content length %d", len(b))
+	}
+
+	// 超出总大小时从最旧的开始删除
+	r.MaxTotalSize = 60
+	if err := r.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	if PathExists(filepath.Join(dir, "2000-01-02.log.gz")) {
+		t.Fatal("oldest file should be removed when over size")
+	}
+}
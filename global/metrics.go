@@ -0,0 +1,325 @@
+package global
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 简易的 Prometheus 指标实现, 输出 text exposition format (0.0.4)
+
+// MetricsContentType /metrics 响应的 Content-Type
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultLatencyBuckets 默认的耗时直方图分桶, 单位秒
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsLock sync.RWMutex
+	metrics     []metric
+)
+
+func registerMetric(m metric) {
+	metricsLock.Lock()
+	metrics = append(metrics, m)
+	metricsLock.Unlock()
+}
+
+// WriteMetrics 输出所有已注册的指标
+func WriteMetrics(w io.Writer) {
+	metricsLock.RLock()
+	ms := metrics
+	metricsLock.RUnlock()
+	for _, m := range ms {
+		m.write(w)
+	}
+}
+
+type metricDesc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *metricDesc) header(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+// labelString 生成 {a="1",b="2"} 形式的标签, extra 为附加的标签对
+func (d *metricDesc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	n := 0
+	add := func(k, v string) {
+		if n > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(v))
+		sb.WriteByte('"')
+		n++
+	}
+	for i, l := range d.labels {
+		add(l, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		add(extra[i], extra[i+1])
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func (d *metricDesc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	metricDesc
+	lock   sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	v      float64
+}
+
+// NewCounterVec 创建并注册一个计数器
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricDesc: metricDesc{name: name, help: help, typ: "counter", labels: labels},
+		values:     map[string]*counterValue{},
+	}
+	registerMetric(c)
+	return c
+}
+
+// Inc 计数加一
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 计数增加v
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	k := c.key(labelValues)
+	c.lock.Lock()
+	cv, ok := c.values[k]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[k] = cv
+	}
+	cv.v += v
+	c.lock.Unlock()
+}
+
+// Value 获取当前计数
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	k := c.key(labelValues)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cv, ok := c.values[k]; ok {
+		return cv.v
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, k := range sortedKeys(c.values) {
+		cv := c.values[k]
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(cv.labels), formatFloat(cv.v))
+	}
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	metricDesc
+	buckets []float64
+	lock    sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec 创建并注册一个直方图, buckets 需升序排列
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		metricDesc: metricDesc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets:    buckets,
+		values:     map[string]*histogramValue{},
+	}
+	registerMetric(h)
+	return h
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	h.lock.Lock()
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+	h.lock.Unlock()
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, k := range sortedKeys(h.values) {
+		hv := h.values[k]
+		for i, b := range h.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(hv.labels, "le", formatFloat(b)), hv.counts[i])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(hv.labels, "le", "+Inf"), hv.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(hv.labels), formatFloat(hv.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(hv.labels), hv.count)
+	}
+}
+
+// GaugeFunc 在输出时计算数值的仪表盘
+type GaugeFunc struct {
+	metricDesc
+	fn func() float64
+}
+
+// NewGaugeFunc 创建并注册一个仪表盘, fn 在每次输出时调用
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricDesc: metricDesc{name: name, help: help, typ: "gauge"}, fn: fn}
+	registerMetric(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	_, _ = fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]*counterValue:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*histogramValue:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// 进程级别的内置指标
+var (
+	ffmpegInvocations = NewCounterVec("gocq_ffmpeg_invocations_total", "Number of ffmpeg invocations.", "operation", "result")
+
+	_ = NewGaugeFunc("gocq_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	_ = NewGaugeFunc("gocq_cache_dir_bytes", "Total size of files in the cache directory, refreshed at most once a minute.",
+		(&dirSizeGauge{dir: CachePath, ttl: time.Minute}).value)
+	_ = NewGaugeFunc("gocq_video_dir_bytes", "Total size of files in the video record directory, refreshed at most once a minute.",
+		(&dirSizeGauge{dir: VideoPath, ttl: time.Minute}).value)
+)
+
+// ResultLabel 根据 err 生成 result 标签值
+func ResultLabel(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "success"
+}
+
+// dirSizeGauge 缓存目录大小的统计结果, 避免每次抓取指标时都遍历目录
+type dirSizeGauge struct {
+	dir  string
+	ttl  time.Duration
+	lock sync.Mutex
+	size int64
+	at   time.Time
+}
+
+func (g *dirSizeGauge) value() float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.at.IsZero() || time.Since(g.at) >= g.ttl {
+		g.size = dirSize(g.dir)
+		g.at = time.Now()
+	}
+	return float64(g.size)
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
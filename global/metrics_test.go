@@ -0,0 +1,53 @@
+package global
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	c := NewCounterVec("test_calls_total", "Test counter.", "action")
+	c.Inc(`a"b`)
+	c.Add(2, "get")
+	h := NewHistogramVec("test_latency_seconds", "Test histogram.", []float64{0.1, 1}, "op")
+	h.Observe(0.5, "x")
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE test_calls_total counter\n",
+		`test_calls_total{action="a\"b"} 1` + "\n",
+		`test_calls_total{action="get"} 2` + "\n",
+		`test_latency_seconds_bucket{op="x",le="0.1"} 0` + "\n",
+		`test_latency_seconds_bucket{op="x",le="1"} 1` + "\n",
+		`test_latency_seconds_bucket{op="x",le="+Inf"} 1` + "\n",
+		`test_latency_seconds_sum{op="x"} 0.5` + "\n",
+		"# TYPE gocq_goroutines gauge\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+}
+
+func TestDirSizeGauge(t *testing.T) {
+	dir := t.TempDir()
+	_ = ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 10), 0644)
+	g := &dirSizeGauge{dir: dir, ttl: time.Hour}
+	if v := g.value(); v != 10 {
+		t.Fatalf("value = %v, want 10", v)
+	}
+	_ = ioutil.WriteFile(filepath.Join(dir, "b"), make([]byte, 5), 0644)
+	if v := g.value(); v != 10 {
+		t.Fatalf("value within ttl = %v, want cached 10", v)
+	}
+	g.at = time.Now().Add(-time.Hour)
+	if v := g.value(); v != 15 {
+		t.Fatalf("value after ttl = %v, want 15", v)
+	}
+}
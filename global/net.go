@@ -2,7 +2,9 @@ package global
 
 import (
 	"bufio"
+	"context"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -249,3 +251,70 @@ func GetSliderTicket(raw, id string) (string, error) {
 	}
 	return g.Get("ticket").Str, nil
 }
+
+// ErrRemoteMissing 远程文件不存在或已失效
+var ErrRemoteMissing = errors.New("remote file missing")
+
+// ProbeURL 请求给定URL的前 size 字节以确认文件可用, 文件不存在时返回 ErrRemoteMissing
+func ProbeURL(ctx context.Context, url string, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header["User-Agent"] = []string{UserAgent}
+	req.Header.Set("range", "bytes=0-"+strconv.FormatInt(size-1, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == 403 || resp.StatusCode == 404 || resp.StatusCode == 410:
+		return ErrRemoteMissing
+	case resp.StatusCode != 200 && resp.StatusCode != 206:
+		return errors.New("response status unsuccessful: " + strconv.FormatInt(int64(resp.StatusCode), 10))
+	}
+	n, err := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, size))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("empty response body")
+	}
+	return nil
+}
+
+// GetBytes 下载给定URL对应的文件到内存, limit 大于0时限制最大长度
+func GetBytes(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header["User-Agent"] = []string{UserAgent}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == 403 || resp.StatusCode == 404 || resp.StatusCode == 410:
+		return nil, ErrRemoteMissing
+	case resp.StatusCode != 200:
+		return nil, errors.New("response status unsuccessful: " + strconv.FormatInt(int64(resp.StatusCode), 10))
+	}
+	if limit > 0 && resp.ContentLength > limit {
+		return nil, ErrOverSize
+	}
+	r := io.Reader(resp.Body)
+	if limit > 0 {
+		r = io.LimitReader(resp.Body, limit+1)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(b)) > limit {
+		return nil, ErrOverSize
+	}
+	return b, nil
+}
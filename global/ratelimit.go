@@ -2,6 +2,8 @@ package global
 
 import (
 	"context"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -9,10 +11,30 @@ import (
 var limiter *rate.Limiter
 var limitEnable = false
 
+var (
+	limitWaiting   int64
+	limitThrottled uint64
+)
+
+// RateLimiterState 限速器状态
+type RateLimiterState struct {
+	Enabled    bool    `json:"enabled"`
+	Frequency  float64 `json:"frequency"`
+	BucketSize int     `json:"bucket_size"`
+	Waiting    int64   `json:"waiting"`   // 正在等待令牌的调用数
+	Throttled  uint64  `json:"throttled"` // 因限速被延迟的调用总数
+}
+
 // RateLimit 执行API调用速率限制
 func RateLimit(ctx context.Context) {
 	if limitEnable {
+		atomic.AddInt64(&limitWaiting, 1)
+		start := time.Now()
 		_ = limiter.Wait(ctx)
+		if time.Since(start) > time.Millisecond {
+			atomic.AddUint64(&limitThrottled, 1)
+		}
+		atomic.AddInt64(&limitWaiting, -1)
 	}
 }
 
@@ -21,3 +43,17 @@ func InitLimiter(frequency float64, bucketSize int) {
 	limitEnable = true
 	limiter = rate.NewLimiter(rate.Limit(frequency), bucketSize)
 }
+
+// GetRateLimiterState 获取限速器状态
+func GetRateLimiterState() RateLimiterState {
+	st := RateLimiterState{
+		Enabled:   limitEnable,
+		Waiting:   atomic.LoadInt64(&limitWaiting),
+		Throttled: atomic.LoadUint64(&limitThrottled),
+	}
+	if limitEnable {
+		st.Frequency = float64(limiter.Limit())
+		st.BucketSize = limiter.Burst()
+	}
+	return st
+}
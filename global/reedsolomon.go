@@ -0,0 +1,257 @@
+package global
+
+import (
+	"errors"
+)
+
+// Reed-Solomon 纠删码, 基于 GF(2^8) (本原多项式 0x11d) 上的系统范德蒙矩阵
+//
+// k 个数据分片生成 m 个校验分片, 任意丢失不超过 m 个分片时均可恢复
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfPow 计算 a^n
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m gfMatrix) mul(o gfMatrix) gfMatrix {
+	r := newGFMatrix(len(m), len(o[0]))
+	for i := range m {
+		for j := range o[0] {
+			var v byte
+			for k := range o {
+				v ^= gfMul(m[i][k], o[k][j])
+			}
+			r[i][j] = v
+		}
+	}
+	return r
+}
+
+// invert 使用高斯-约旦消元求逆矩阵
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newGFMatrix(n, n*2)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errors.New("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if inv := gfInv(work[col][col]); inv != 1 {
+			for j := range work[col] {
+				work[col][j] = gfMul(work[col][j], inv)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			f := work[r][col]
+			for j := range work[r] {
+				work[r][j] ^= gfMul(f, work[col][j])
+			}
+		}
+	}
+	inv := newGFMatrix(n, n)
+	for i := range inv {
+		copy(inv[i], work[i][n:])
+	}
+	return inv, nil
+}
+
+// ReedSolomon 纠删码编码器
+type ReedSolomon struct {
+	DataShards   int
+	ParityShards int
+	matrix       gfMatrix // (k+m)*k, 前 k 行为单位矩阵
+}
+
+// ErrTooFewShards 可用分片不足, 无法恢复
+var ErrTooFewShards = errors.New("too few shards to reconstruct")
+
+// NewReedSolomon 创建 k 个数据分片, m 个校验分片的编码器
+func NewReedSolomon(k, m int) (*ReedSolomon, error) {
+	if k <= 0 || m < 0 || k+m > 256 {
+		return nil, errors.New("invalid shard count")
+	}
+	vm := newGFMatrix(k+m, k)
+	for r := range vm {
+		for c := range vm[r] {
+			vm[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top := newGFMatrix(k, k)
+	for i := range top {
+		copy(top[i], vm[i])
+	}
+	inv, err := top.invert()
+	if err != nil {
+		return nil, err
+	}
+	return &ReedSolomon{DataShards: k, ParityShards: m, matrix: vm.mul(inv)}, nil
+}
+
+func (r *ReedSolomon) checkShards(shards [][]byte, allowNil bool) (int, error) {
+	if len(shards) != r.DataShards+r.ParityShards {
+		return 0, errors.New("wrong number of shards")
+	}
+	size := -1
+	for _, s := range shards {
+		if s == nil {
+			if !allowNil {
+				return 0, errors.New("missing shard")
+			}
+			continue
+		}
+		if size >= 0 && len(s) != size {
+			return 0, errors.New("shard sizes differ")
+		}
+		size = len(s)
+	}
+	if size < 0 {
+		return 0, ErrTooFewShards
+	}
+	return size, nil
+}
+
+// Encode 根据 shards 中的前 k 个数据分片计算后 m 个校验分片, 所有分片长度需相同
+func (r *ReedSolomon) Encode(shards [][]byte) error {
+	size, err := r.checkShards(shards, true)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < r.DataShards; i++ {
+		if shards[i] == nil {
+			return errors.New("missing data shard")
+		}
+	}
+	for i := r.DataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+		}
+		r.codeShard(r.matrix[i], shards[:r.DataShards], shards[i])
+	}
+	return nil
+}
+
+// codeShard out = Σ row[j] * in[j]
+func (r *ReedSolomon) codeShard(row []byte, in [][]byte, out []byte) {
+	for i := range out {
+		out[i] = 0
+	}
+	for j, c := range row {
+		if c == 0 {
+			continue
+		}
+		src := in[j]
+		for i := range out {
+			out[i] ^= gfMul(c, src[i])
+		}
+	}
+}
+
+// Reconstruct 恢复 shards 中为 nil 的分片, 可用分片数需不少于 k
+func (r *ReedSolomon) Reconstruct(shards [][]byte) error {
+	size, err := r.checkShards(shards, true)
+	if err != nil {
+		return err
+	}
+	var rows []int
+	for i, s := range shards {
+		if s != nil && len(rows) < r.DataShards {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < r.DataShards {
+		return ErrTooFewShards
+	}
+	dataMissing := false
+	for i := 0; i < r.DataShards; i++ {
+		if shards[i] == nil {
+			dataMissing = true
+			break
+		}
+	}
+	if dataMissing {
+		sub := newGFMatrix(r.DataShards, r.DataShards)
+		in := make([][]byte, r.DataShards)
+		for i, row := range rows {
+			copy(sub[i], r.matrix[row])
+			in[i] = shards[row]
+		}
+		dec, err := sub.invert()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < r.DataShards; i++ {
+			if shards[i] == nil {
+				shards[i] = make([]byte, size)
+				r.codeShard(dec[i], in, shards[i])
+			}
+		}
+	}
+	for i := r.DataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			r.codeShard(r.matrix[i], shards[:r.DataShards], shards[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package global
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	rs, err := NewReedSolomon(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	shards := make([][]byte, 6)
+	for i := 0; i < 4; i++ {
+		shards[i] = make([]byte, 1000)
+		rnd.Read(shards[i])
+	}
+	if err = rs.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+	orig := make([][]byte, len(shards))
+	for i := range shards {
+		orig[i] = append([]byte(nil), shards[i]...)
+	}
+	for a := 0; a < 6; a++ {
+		for b := a + 1; b < 6; b++ {
+			s := make([][]byte, len(orig))
+			copy(s, orig)
+			s[a], s[b] = nil, nil
+			if err := rs.Reconstruct(s); err != nil {
+				t.Fatalf("reconstruct without %d,%d: %v", a, b, err)
+			}
+			for i := range s {
+				if !bytes.Equal(s[i], orig[i]) {
+					t.Fatalf("shard %d mismatch after losing %d,%d", i, a, b)
+				}
+			}
+		}
+	}
+	s := make([][]byte, len(orig))
+	copy(s, orig)
+	s[0], s[1], s[5] = nil, nil, nil
+	if err := rs.Reconstruct(s); err != ErrTooFewShards {
+		t.Fatalf("expected ErrTooFewShards, got %v", err)
+	}
+}
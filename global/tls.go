@@ -0,0 +1,141 @@
+package global
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// certCheckInterval 检查证书文件是否更新的最小间隔
+const certCheckInterval = time.Second * 10
+
+// CertReloader 在证书文件更新后自动重新加载证书
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader 加载给定证书与私钥, 并在文件更新后自动重新加载
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) modified() time.Time {
+	var t time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return t
+}
+
+func (r *CertReloader) reload() error {
+	modTime := r.modified()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "load certificate failed")
+	}
+	r.lock.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.lock.Unlock()
+	return nil
+}
+
+// GetCertificate 用于 tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	cert, modTime, lastCheck := r.cert, r.modTime, r.lastCheck
+	r.lock.RUnlock()
+	if time.Since(lastCheck) < certCheckInterval {
+		return cert, nil
+	}
+	r.lock.Lock()
+	r.lastCheck = time.Now()
+	r.lock.Unlock()
+	if r.modified().After(modTime) {
+		if err := r.reload(); err != nil {
+			log.Warnf("重新加载证书 %v 失败, 将继续使用旧证书: %v", r.certFile, err)
+			return cert, nil
+		}
+		log.Infof("已重新加载证书 %v", r.certFile)
+		r.lock.RLock()
+		cert = r.cert
+		r.lock.RUnlock()
+	}
+	return cert, nil
+}
+
+// LoadCertPool 加载PEM格式的CA证书, 结果包含系统根证书
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no valid certificate found in " + file)
+	}
+	return pool, nil
+}
+
+// NewServerTLSConfig 生成服务端TLS配置, 未配置证书时返回nil
+//
+// clientCA 不为空时将要求客户端提供由该CA签发的证书
+func NewServerTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCA != "" {
+		b, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("no valid certificate found in " + clientCA)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// NewClientTLSConfig 生成客户端TLS配置, caFile 为空时返回nil(使用系统根证书)
+func NewClientTLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}, nil
+}
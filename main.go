@@ -8,7 +8,6 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	easy "github.com/t-tomalak/logrus-easy-formatter"
 	"io"
 	"io/ioutil"
@@ -16,7 +15,6 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -43,16 +41,6 @@ var conf *global.JSONConfig
 var isFastStart = false
 
 func init() {
-	logFormatter := &easy.Formatter{
-		TimestampFormat: "2006-01-02 15:04:05",
-		LogFormat:       "[%time%] [%lvl%]: %msg% \n",
-	}
-	w, err := rotatelogs.New(path.Join("logs", "%Y-%m-%d.log"), rotatelogs.WithRotationTime(time.Hour*24))
-	if err != nil {
-		log.Errorf("rotatelogs init err: %v", err)
-		panic(err)
-	}
-
 	conf = getConfig()
 	if conf == nil {
 		os.Exit(1)
@@ -63,7 +51,43 @@ func init() {
 		log.SetReportCaller(true)
 	}
 
+	var logFormatter log.Formatter = &easy.Formatter{
+		TimestampFormat: "2006-01-02 15:04:05",
+		LogFormat:       "[%time%] [%lvl%]: %msg% \n",
+	}
+	switch conf.LogFormat {
+	case "json":
+		logFormatter = &log.JSONFormatter{TimestampFormat: "2006-01-02T15:04:05.000Z07:00"}
+	case "", "text":
+	default:
+		log.Warnf("未知的日志格式 %v, 将使用 text", conf.LogFormat)
+	}
+	retention := &global.LogRetention{Dir: "logs", MaxAge: time.Hour * 24 * 7}
+	if c := conf.LogRetention; c != nil {
+		retention.MaxAge = time.Hour * 24 * time.Duration(c.MaxAge)
+		retention.MaxTotalSize = c.MaxTotalSize * 1024 * 1024
+		retention.Compress = c.Compress
+	}
+	w, err := retention.NewWriter(".log")
+	if err != nil {
+		log.Errorf("rotatelogs init err: %v", err)
+		panic(err)
+	}
 	log.AddHook(global.NewLocalHook(w, logFormatter, global.GetLogLevel(conf.LogLevel)...))
+	if conf.LogRetention != nil && conf.LogRetention.ErrorFile {
+		ew, err := retention.NewWriter(".error.log")
+		if err != nil {
+			log.Errorf("rotatelogs init err: %v", err)
+			panic(err)
+		}
+		log.AddHook(global.NewLocalHook(ew, logFormatter, global.ErrorLevels...))
+	}
+	log.AddHook(global.LogRing)
+	go func() {
+		if err := retention.Clean(); err != nil {
+			log.Warnf("清理日志文件时出现错误: %v", err)
+		}
+	}()
 
 	if global.PathExists("cqhttp.json") {
 		log.Info("发现 cqhttp.json 将在五秒后尝试导入配置，按 Ctrl+C 取消.")
@@ -105,10 +129,16 @@ func init() {
 			log.Fatalf("创建缓存文件夹失败: %v", err)
 		}
 	}
+	if c := conf.Cache; c != nil {
+		global.Cache.MaxSize = c.MaxSize * 1024 * 1024
+		global.Cache.MaxAge = time.Hour * time.Duration(c.MaxAge)
+		global.Cache.Start(time.Minute * time.Duration(c.CleanInterval))
+	}
 }
 
 func main() {
 	var byteKey []byte
+	var command []string
 	arg := os.Args
 	if len(arg) > 1 {
 		for i := range arg {
@@ -126,6 +156,11 @@ func main() {
 				}
 			case "faststart":
 				isFastStart = true
+			case "put", "get", "ls", "info", "rm", "backup", "restore":
+				command = arg[i:]
+			}
+			if command != nil { // 子命令之后的参数均属于子命令
+				break
 			}
 		}
 	}
@@ -148,6 +183,62 @@ func main() {
 		}
 	}
 	log.Info("用户交流群: 721829413")
+	if len(command) > 0 {
+		os.Exit(runCommand(command, byteKey))
+	}
+	loadAccount(byteKey)
+	if !isFastStart {
+		log.Info("Bot将在5秒后登录并开始信息处理, 按 Ctrl+C 取消.")
+		time.Sleep(time.Second * 5)
+	}
+	log.Info("开始尝试登录并同步消息...")
+	log.Infof("使用协议: %v", func() string {
+		switch client.SystemDeviceInfo.Protocol {
+		case client.IPad:
+			return "iPad"
+		case client.AndroidPhone:
+			return "Android Phone"
+		case client.AndroidWatch:
+			return "Android Watch"
+		case client.MacOS:
+			return "MacOS"
+		}
+		return "未知"
+	}())
+	cli := newClient()
+	if conf.WebUI == nil {
+		conf.WebUI = &global.GoCQWebUI{
+			Enabled:   true,
+			WebInput:  false,
+			Host:      "0.0.0.0",
+			WebUIPort: 9999,
+		}
+	}
+	if conf.WebUI.WebUIPort <= 0 {
+		conf.WebUI.WebUIPort = 9999
+	}
+	if conf.WebUI.Host == "" {
+		conf.WebUI.Host = "127.0.0.1"
+	}
+	global.Proxy = conf.ProxyRewrite
+	server.WebServer.Run(server.ListenAddr(conf.WebUI.Host, conf.WebUI.WebUIPort), cli)
+	c := server.Console
+	r := server.Restart
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		log.Info("正在关闭...")
+		server.WebServer.Shutdown()
+		os.Exit(0)
+	}()
+	for range r {
+		log.Info("正在重启中...")
+		restart(arg)
+	}
+}
+
+// loadAccount 加载设备信息并解密密码
+func loadAccount(byteKey []byte) {
 	if !global.PathExists("device.json") {
 		log.Warn("虚拟设备信息不存在, 将自动生成随机设备.")
 		client.GenRandomDevice()
@@ -206,24 +297,10 @@ func main() {
 	} else {
 		global.PasswordHash = md5.Sum([]byte(conf.Password))
 	}
-	if !isFastStart {
-		log.Info("Bot将在5秒后登录并开始信息处理, 按 Ctrl+C 取消.")
-		time.Sleep(time.Second * 5)
-	}
-	log.Info("开始尝试登录并同步消息...")
-	log.Infof("使用协议: %v", func() string {
-		switch client.SystemDeviceInfo.Protocol {
-		case client.IPad:
-			return "iPad"
-		case client.AndroidPhone:
-			return "Android Phone"
-		case client.AndroidWatch:
-			return "Android Watch"
-		case client.MacOS:
-			return "MacOS"
-		}
-		return "未知"
-	}())
+}
+
+// newClient 使用配置中的账号创建协议客户端
+func newClient() *client.QQClient {
 	cli := client.NewClientMd5(conf.Uin, global.PasswordHash)
 	cli.OnLog(func(c *client.QQClient, e *client.LogEvent) {
 		switch e.Type {
@@ -251,29 +328,7 @@ func main() {
 		log.Infof("收到服务器地址更新通知, 将在下一次重连时应用. ")
 		return true
 	})
-	if conf.WebUI == nil {
-		conf.WebUI = &global.GoCQWebUI{
-			Enabled:   true,
-			WebInput:  false,
-			Host:      "0.0.0.0",
-			WebUIPort: 9999,
-		}
-	}
-	if conf.WebUI.WebUIPort <= 0 {
-		conf.WebUI.WebUIPort = 9999
-	}
-	if conf.WebUI.Host == "" {
-		conf.WebUI.Host = "127.0.0.1"
-	}
-	global.Proxy = conf.ProxyRewrite
-	server.WebServer.Run(fmt.Sprintf("%s:%d", conf.WebUI.Host, conf.WebUI.WebUIPort), cli)
-	c := server.Console
-	r := server.Restart
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	for range r {
-		log.Info("正在重启中...")
-		restart(arg)
-	}
+	return cli
 }
 
 // PasswordHashEncrypt 使用key加密给定passwordHash
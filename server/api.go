@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/sam01101/gocq-qqdrive/coolq"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
-	"strings"
 )
 
 type resultGetter interface {
@@ -11,30 +16,60 @@ type resultGetter interface {
 }
 
 type apiCaller struct {
-	bot *coolq.CQBot
+	bot   *coolq.CQBot
+	scope *tokenScope
 }
 
-func getLoginInfo(bot *coolq.CQBot, _ resultGetter) coolq.MSG {
+func getLoginInfo(_ context.Context, bot *coolq.CQBot, _ resultGetter) coolq.MSG {
 	return bot.CQGetLoginInfo()
 }
 
-func uploadShortVideo(bot *coolq.CQBot, p resultGetter) coolq.MSG {
-	return bot.CQUploadShortVideo(p.Get("file").String())
+func getStatus(_ context.Context, bot *coolq.CQBot, _ resultGetter) coolq.MSG {
+	r := bot.CQGetStatus()
+	if data, ok := r["data"].(coolq.MSG); ok {
+		data["connections"] = WebServer.connectionStats()
+	}
+	return r
+}
+
+func getVersionInfo(_ context.Context, bot *coolq.CQBot, _ resultGetter) coolq.MSG {
+	return bot.CQGetVersionInfo()
+}
+
+func getSupportedActions(_ context.Context, _ *coolq.CQBot, _ resultGetter) coolq.MSG {
+	actions := make([]string, 0, len(API))
+	for k := range API {
+		actions = append(actions, k)
+	}
+	sort.Strings(actions)
+	r := make([]coolq.MSG, 0, len(actions))
+	for _, action := range actions {
+		params := apiParams[action]
+		if params == nil {
+			params = []apiParam{}
+		}
+		r = append(r, coolq.MSG{"action": action, "params": params})
+	}
+	return coolq.OK(coolq.MSG{"actions": r})
+}
+
+func uploadShortVideo(ctx context.Context, bot *coolq.CQBot, p resultGetter) coolq.MSG {
+	return bot.CQUploadShortVideo(ctx, p.Get("file").String())
 }
 
-func sendGroupForwardMSG(bot *coolq.CQBot, p resultGetter) coolq.MSG {
-	return bot.CQSendGroupForwardMessage(p.Get("messages"))
+func sendGroupForwardMSG(ctx context.Context, bot *coolq.CQBot, p resultGetter) coolq.MSG {
+	return bot.CQSendGroupForwardMessage(ctx, p.Get("messages"))
 }
 
-func getForwardMSG(bot *coolq.CQBot, p resultGetter) coolq.MSG {
+func getForwardMSG(ctx context.Context, bot *coolq.CQBot, p resultGetter) coolq.MSG {
 	id := p.Get("message_id").Str
 	if id == "" {
 		id = p.Get("id").Str
 	}
-	return bot.CQGetForwardMessage(id)
+	return bot.CQGetForwardMessage(ctx, id)
 }
 
-func downloadFile(bot *coolq.CQBot, p resultGetter) coolq.MSG {
+func downloadFile(ctx context.Context, bot *coolq.CQBot, p resultGetter) coolq.MSG {
 	headers := map[string]string{}
 	headersToken := p.Get("headers")
 	if headersToken.IsArray() {
@@ -54,21 +89,98 @@ func downloadFile(bot *coolq.CQBot, p resultGetter) coolq.MSG {
 			}
 		}
 	}
-	return bot.CQDownloadFile(p.Get("url").Str, headers, int(p.Get("thread_count").Int()))
+	return bot.CQDownloadFile(ctx, p.Get("url").Str, headers, int(p.Get("thread_count").Int()))
 }
 
-var API = map[string]func(*coolq.CQBot, resultGetter) coolq.MSG{
+func uploadChunkedFile(ctx context.Context, bot *coolq.CQBot, p resultGetter) coolq.MSG {
+	return bot.CQUploadChunkedFile(ctx, p.Get("file").String(), p.Get("part_size").Int(),
+		int(p.Get("data_shards").Int()), int(p.Get("parity_shards").Int()), p.Get("compression").String(), p.Get("chunking").String())
+}
+
+func downloadChunkedFile(ctx context.Context, bot *coolq.CQBot, p resultGetter) coolq.MSG {
+	return bot.CQDownloadChunkedFile(ctx, p.Get("message_id").String(), p.Get("file").String())
+}
+
+var API = map[string]func(context.Context, *coolq.CQBot, resultGetter) coolq.MSG{
 	"get_login_info":         getLoginInfo,
 	"upload_short_video":     uploadShortVideo,
 	"send_group_forward_msg": sendGroupForwardMSG,
 	"get_forward_msg":        getForwardMSG,
 	"download_file":          downloadFile,
+	"get_status":             getStatus,
+	"get_version_info":       getVersionInfo,
+	"upload_chunked_file":    uploadChunkedFile,
+	"download_chunked_file":  downloadChunkedFile,
+}
+
+func init() {
+	// 避免初始化循环引用
+	API["get_supported_actions"] = getSupportedActions
 }
 
-func (api *apiCaller) callAPI(action string, p resultGetter) coolq.MSG {
-	if f, ok := API[action]; ok {
-		return f(api.bot, p)
+// apiParam API参数说明, 用于 get_supported_actions
+type apiParam struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+}
+
+// apiParams 各API的参数说明, 新增API时需同步添加
+var apiParams = map[string][]apiParam{
+	"get_login_info":        {},
+	"get_status":            {},
+	"get_version_info":      {},
+	"get_supported_actions": {},
+	"upload_short_video": {
+		{Name: "file", Type: "string", Required: true, Description: "本地视频文件路径"},
+	},
+	"send_group_forward_msg": {
+		{Name: "messages", Type: "node[]", Required: true, Description: "合并转发节点列表"},
+	},
+	"get_forward_msg": {
+		{Name: "message_id", Type: "string", Required: true, Description: "合并转发ID, 也可使用 id"},
+	},
+	"upload_chunked_file": {
+		{Name: "file", Type: "string", Required: true, Description: "本地文件路径"},
+		{Name: "part_size", Type: "int64", Required: false, Description: "分块大小(字节), 默认使用配置"},
+		{Name: "data_shards", Type: "int", Required: false, Description: "每个条带的数据分块数, 默认使用配置"},
+		{Name: "parity_shards", Type: "int", Required: false, Description: "每个条带的校验分块数, 默认使用配置"},
+		{Name: "compression", Type: "string", Required: false, Description: "压缩算法 none/gzip/zstd/auto, 默认使用配置"},
+		{Name: "chunking", Type: "string", Required: false, Description: "分块方式 fixed/cdc, 默认使用配置"},
+	},
+	"download_chunked_file": {
+		{Name: "message_id", Type: "string", Required: true, Description: "分块上传返回的合并转发ID"},
+		{Name: "file", Type: "string", Required: false, Description: "保存路径, 默认保存到缓存目录"},
+	},
+	"download_file": {
+		{Name: "url", Type: "string", Required: true, Description: "下载地址"},
+		{Name: "thread_count", Type: "int", Required: false, Description: "下载线程数"},
+		{Name: "headers", Type: "string|string[]", Required: false, Description: "自定义请求头, 格式为 key=value, 字符串时以 \\r\\n 分隔"},
+	},
+}
+
+func (api *apiCaller) callAPI(ctx context.Context, action string, p resultGetter) coolq.MSG {
+	start := time.Now()
+	ctx = coolq.WithLogFields(ctx, log.Fields{"action": action})
+	var ret coolq.MSG
+	if !api.scope.allow(action) {
+		coolq.Logger(ctx).Warnf("已拒绝令牌 %v 调用API %v: 权限不足", api.scope.name, action)
+		ret = coolq.Failed(403, "PERMISSION_DENIED", "令牌无权调用该API")
+	} else if f, ok := API[action]; ok {
+		ret = f(ctx, api.bot, p)
+	} else {
+		ret = coolq.Failed(404, "API_NOT_FOUND", "API不存在")
+	}
+	observeAPICall(action, ret, time.Since(start))
+	entry := coolq.Logger(ctx).WithFields(log.Fields{
+		"retcode":     ret["retcode"],
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	if ret["status"] == "ok" {
+		entry.Debugf("API调用完成: %v", action)
 	} else {
-		return coolq.Failed(404, "API_NOT_FOUND", "API不存在")
+		entry.Infof("API调用失败: %v (%v)", action, ret["msg"])
 	}
+	return ret
 }
@@ -1,18 +1,16 @@
 package server
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
-	"image"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/sam01101/MiraiGo-qdrive/utils"
@@ -25,7 +23,6 @@ import (
 	jsoniter "github.com/json-iterator/go"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
-	asciiart "github.com/yinghau76/go-ascii-art"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -43,29 +40,41 @@ var Restart = make(chan struct{}, 1)
 var JSONConfig *global.JSONConfig
 
 type webServer struct {
-	engine  *gin.Engine
-	bot     *coolq.CQBot
-	Cli     *client.QQClient
-	Conf    *global.JSONConfig //old config
-	Console *bufio.Reader
+	engine   *gin.Engine
+	bot      *coolq.CQBot
+	Cli      *client.QQClient
+	Conf     *global.JSONConfig //old config
+	services []service
+	cancel   context.CancelFunc
+	svcMutex sync.Mutex
+	headless bool // 由 Login 登录, 登录失败时直接以 LoginFailedExitCode 退出
 }
 
 // WebServer Admin子站的Server
 var WebServer = &webServer{}
 
+// LoginFailedExitCode 子命令登录失败时的退出码
+const LoginFailedExitCode = 5
+
 // APIAdminRoutingTable Admin子站的路由映射
 var APIAdminRoutingTable = map[string]func(s *webServer, c *gin.Context){
-	"do_restart":         AdminDoRestart,         //热重启
-	"do_process_restart": AdminProcessRestart,    //进程重启
-	"get_web_write":      AdminWebWrite,          //获取是否验证码输入
-	"do_web_write":       AdminDoWebWrite,        //web上进行输入操作
-	"do_restart_docker":  AdminDoRestartDocker,   //直接停止（依赖supervisord/docker）重新拉起
-	"do_config_base":     AdminDoConfigBase,      //修改config.json中的基础部分
-	"do_config_http":     AdminDoConfigHTTP,      //修改config.json的http部分
-	"do_config_ws":       AdminDoConfigWS,        //修改config.json的正向ws部分
-	"do_config_reverse":  AdminDoConfigReverseWS, //修改config.json 中的反向ws部分
-	"do_config_json":     AdminDoConfigJSON,      //直接修改 config.json配置
-	"get_config_json":    AdminGetConfigJSON,     //拉取 当前的config.json配置
+	"do_restart":            AdminDoRestart,           //热重启
+	"do_process_restart":    AdminProcessRestart,      //进程重启
+	"get_web_write":         AdminWebWrite,            //获取是否验证码输入
+	"do_web_write":          AdminDoWebWrite,          //web上进行输入操作
+	"do_restart_docker":     AdminDoRestartDocker,     //直接停止（依赖supervisord/docker）重新拉起
+	"do_config_base":        AdminDoConfigBase,        //修改config.json中的基础部分
+	"do_config_http":        AdminDoConfigHTTP,        //修改config.json的http部分
+	"do_config_ws":          AdminDoConfigWS,          //修改config.json的正向ws部分
+	"do_config_reverse":     AdminDoConfigReverseWS,   //修改config.json 中的反向ws部分
+	"do_config_json":        AdminDoConfigJSON,        //直接修改 config.json配置
+	"get_config_json":       AdminGetConfigJSON,       //拉取 当前的config.json配置
+	"get_logs":              AdminGetLogs,             //查询内存中的最近日志
+	"get_cache_stats":       AdminGetCacheStats,       //获取缓存目录统计
+	"clean_cache":           AdminCleanCache,          //清理缓存目录
+	"migrate_cache_records": AdminMigrateCacheRecords, //迁移旧格式的视频记录
+	"get_health_report":     AdminGetHealthReport,     //获取最近一次健康检查报告
+	"do_health_check":       AdminDoHealthCheck,       //立即执行健康检查
 }
 
 // Failed 构建失败返回MSG
@@ -84,6 +93,8 @@ func (s *webServer) Run(addr string, cli *client.QQClient) *coolq.CQBot {
 
 	// 通用路由
 	s.engine.Any("/admin/:action", s.admin)
+	s.engine.GET("/admin/:action/stream", s.adminLogsStream)
+	s.engine.GET("/metrics", s.metrics)
 
 	go func() {
 		// 开启端口监听
@@ -93,39 +104,59 @@ func (s *webServer) Run(addr string, cli *client.QQClient) *coolq.CQBot {
 				log.Debugf("pprof 性能分析服务已启动在 http://%v/debug/pprof, 如果有任何性能问题请下载报告并提交给开发者", addr)
 				time.Sleep(time.Second * 3)
 			}
-			log.Infof("Admin API 服务器已启动: %v", addr)
-			err := s.engine.Run(addr)
+			ui := s.Conf.WebUI
+			lc, err := newListenConfig(addr, ui.UnixSocketMode, ui.TLSCert, ui.TLSKey, ui.TLSClientCA)
+			if err == nil {
+				var ln net.Listener
+				if ln, err = listen(lc); err == nil {
+					log.Infof("Admin API 服务器已启动: %v", lc)
+					err = http.Serve(ln, s.engine)
+				}
+			}
 			if err != nil {
 				log.Error(err)
-				log.Infof("请检查端口是否被占用.")
-				c := make(chan os.Signal, 1)
-				signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-				<-c
-				os.Exit(1)
+				log.Infof("请检查端口是否被占用或监听配置是否正确.")
 			}
-		} else {
-			// 关闭端口监听
-			c := make(chan os.Signal, 1)
-			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-			<-c
-			os.Exit(1)
 		}
 	}()
 	s.Dologin()
-	s.UpServer()
+	if err := s.UpServer(); err != nil {
+		log.Infof("服务启动失败, 请检查端口是否被占用或监听配置是否正确.")
+		log.Warnf("将在五秒后退出.")
+		time.Sleep(time.Second * 5)
+		os.Exit(1)
+	}
 	b := s.bot // 外部引入 bot对象，用于操作bot
 	return b
 }
 
+// Login 仅登录并创建 bot, 不启动 Admin API 与 OneBot 服务, 用于执行子命令
+func (s *webServer) Login(cli *client.QQClient) *coolq.CQBot {
+	s.Cli = cli
+	s.Conf = GetConf()
+	JSONConfig = s.Conf
+	s.headless = true
+	s.Cli.AllowSlider = true
+	s.logincore(false)
+	log.Infof("登录成功 欢迎使用: %v", s.Cli.Nickname)
+	s.bot = coolq.NewQQBot(s.Cli, s.Conf)
+	return s.bot
+}
+
 // logincore 登录核心实现
 func (s *webServer) logincore(relogin bool) {
 
-	s.Console = bufio.NewReader(os.Stdin)
-	readLine := func() (str string) {
-		str, _ = s.Console.ReadString('\n')
-		str = strings.TrimSpace(str)
-		return
+	provider := newChallengeProvider(s.Conf)
+	solve := func(c *Challenge) string {
+		c.SelfID = s.Cli.Uin
+		text, err := provider.Solve(c)
+		if err != nil {
+			log.Warnf("获取登录验证 %v 的输入失败: %v", c.Type, err)
+			s.loginFailed()
+		}
+		return text
 	}
+	_, console := provider.(*consoleChallenge)
 
 	if s.Cli.Online {
 		log.Warn("Bot已登录")
@@ -156,23 +187,11 @@ func (s *webServer) logincore(relogin bool) {
 			log.Warnf("2. 使用Cef自动处理.")
 			log.Warnf("3. 不提交滑块并继续.(可能会导致上网环境异常错误)")
 			log.Warnf("详细信息请参考文档 -> https://github.com/sam01101/gocq-qqdrive/blob/master/docs/slider.md <-")
-			if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-				log.Warnf("请输入(1 - 3)： (http://%s:%d/admin/do_web_write 输入)", s.Conf.WebUI.Host, s.Conf.WebUI.WebUIPort)
-				text = <-WebInput
-			} else {
-				log.Warn("请输入(1 - 3)：")
-				text = readLine()
-			}
+			text = solve(&Challenge{Type: ChallengeSliderMethod, Message: "请输入(1 - 3)：", URL: res.VerifyUrl})
 			if strings.Contains(text, "1") {
 				log.Warnf("请用浏览器打开 -> %v <- 并获取Ticket.", res.VerifyUrl)
-				if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-					log.Warnf("请输入Ticket： (http://%s:%d/admin/do_web_write 输入)", s.Conf.WebUI.Host, s.Conf.WebUI.WebUIPort)
-					text = <-WebInput
-				} else {
-					log.Warn("请输入Ticket： (Enter 提交)")
-					text = readLine()
-				}
-				res, err = s.Cli.SubmitTicket(strings.TrimSpace(text))
+				text = solve(&Challenge{Type: ChallengeTicket, Message: "请输入Ticket：", URL: res.VerifyUrl})
+				res, err = s.Cli.SubmitTicket(text)
 				goto Again
 			}
 			if strings.Contains(text, "3") {
@@ -180,14 +199,8 @@ func (s *webServer) logincore(relogin bool) {
 				s.Cli.Disconnect()
 				continue
 			}
-			id := utils.RandomStringRange(6, "0123456789")
-			log.Warnf("滑块ID为 %v 请在30S内处理.", id)
-			ticket, err := global.GetSliderTicket(res.VerifyUrl, id)
-			if err != nil {
-				log.Warnf("错误: " + err.Error())
-				os.Exit(0)
-			}
-			res, err = s.Cli.SubmitTicket(ticket)
+			text = solve(&Challenge{Type: ChallengeSlider, Message: "请处理滑块验证码并提交Ticket：", URL: res.VerifyUrl, ID: utils.RandomStringRange(6, "0123456789")})
+			res, err = s.Cli.SubmitTicket(text)
 			if err != nil {
 				log.Warnf("错误: " + err.Error())
 				continue // 尝试重新登录
@@ -195,80 +208,45 @@ func (s *webServer) logincore(relogin bool) {
 			goto Again
 		case client.NeedCaptcha:
 			_ = ioutil.WriteFile("captcha.jpg", res.CaptchaImage, 0644)
-			img, _, _ := image.Decode(bytes.NewReader(res.CaptchaImage))
-			fmt.Println(asciiart.New("image", img).Art)
-			if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-				log.Warnf("请输入验证码 (captcha.jpg)： (http://%s:%d/admin/do_web_write 输入)", s.Conf.WebUI.Host, s.Conf.WebUI.WebUIPort)
-				text = <-WebInput
-			} else {
-				log.Warn("请输入验证码 (captcha.jpg)： (Enter 提交)")
-				text = readLine()
-			}
+			text = solve(&Challenge{Type: ChallengeCaptcha, Message: "请输入验证码 (captcha.jpg)：", Image: res.CaptchaImage})
 			global.DelFile("captcha.jpg")
 			res, err = s.Cli.SubmitCaptcha(strings.ReplaceAll(text, "\n", ""), res.CaptchaSign)
 			goto Again
 		case client.SMSNeededError:
-			if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-				log.Warnf("账号已开启设备锁, 已向手机 %v 发送短信验证码.", res.SMSPhone)
+			if console {
+				solve(&Challenge{Type: ChallengeSMS, Message: fmt.Sprintf("账号已开启设备锁, 按下 Enter 向手机 %v 发送短信验证码.", res.SMSPhone), Phone: res.SMSPhone})
 			} else {
-				log.Warnf("账号已开启设备锁, 按下 Enter 向手机 %v 发送短信验证码.", res.SMSPhone)
-				readLine()
+				log.Warnf("账号已开启设备锁, 已向手机 %v 发送短信验证码.", res.SMSPhone)
 			}
 			if !s.Cli.RequestSMS() {
 				log.Warnf("发送验证码失败，可能是请求过于频繁.")
 				time.Sleep(time.Second * 5)
 				continue
 			}
-			if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-				log.Warnf("请输入短信验证码： (http://%s:%d/admin/do_web_write 输入)", s.Conf.WebUI.Host, s.Conf.WebUI.WebUIPort)
-				text = <-WebInput
-			} else {
-				log.Warn("请输入短信验证码： (Enter 提交)")
-				text = readLine()
-			}
+			text = solve(&Challenge{Type: ChallengeSMS, Message: "请输入短信验证码：", Phone: res.SMSPhone})
 			res, err = s.Cli.SubmitSMS(strings.ReplaceAll(strings.ReplaceAll(text, "\n", ""), "\r", ""))
 			goto Again
 		case client.SMSOrVerifyNeededError:
 			log.Warnf("账号已开启设备锁，请选择验证方式:")
 			log.Warnf("1. 向手机 %v 发送短信验证码", res.SMSPhone)
 			log.Warnf("2. 使用手机QQ扫码验证.")
-			if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-				log.Warnf("请输入(1 - 2)： (http://%s:%d/admin/do_web_write 输入)", s.Conf.WebUI.Host, s.Conf.WebUI.WebUIPort)
-				text = <-WebInput
-			} else {
-				log.Warn("请输入(1 - 2)：")
-				text = readLine()
-			}
+			text = solve(&Challenge{Type: ChallengeVerifyMethod, Message: "请输入(1 - 2)：", URL: res.VerifyUrl, Phone: res.SMSPhone})
 			if strings.Contains(text, "1") {
 				if !s.Cli.RequestSMS() {
 					log.Warnf("发送验证码失败，可能是请求过于频繁.")
 					time.Sleep(time.Second * 5)
-					os.Exit(0)
-				}
-				if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-					log.Warnf("请输入短信验证码：  (http://%s:%d/admin/do_web_write 输入)....", s.Conf.WebUI.Host, s.Conf.WebUI.WebUIPort)
-					text = <-WebInput
-				} else {
-					log.Warn("请输入短信验证码： (Enter 提交)")
-					text = readLine()
+					s.loginFailed()
 				}
+				text = solve(&Challenge{Type: ChallengeSMS, Message: "请输入短信验证码：", Phone: res.SMSPhone})
 				res, err = s.Cli.SubmitSMS(strings.ReplaceAll(strings.ReplaceAll(text, "\n", ""), "\r", ""))
 				goto Again
 			}
 			log.Warnf("请前往 -> %v <- 验证.", res.VerifyUrl)
-			log.Infof("按 Enter 继续....")
-			readLine()
+			solve(&Challenge{Type: ChallengeDeviceLock, Message: "验证完成后继续....", URL: res.VerifyUrl})
 			continue
 		case client.UnsafeDeviceError:
 			log.Warnf("账号已开启设备锁，请前往 -> %v <- 验证.", res.VerifyUrl)
-			if s.Conf.WebUI != nil && s.Conf.WebUI.WebInput {
-				log.Infof(" (http://%s:%d/admin/do_web_write 确认后继续)....", s.Conf.WebUI.Host, s.Conf.WebUI.WebUIPort)
-				text = <-WebInput
-			} else {
-				log.Infof("按 Enter 继续....")
-				readLine()
-			}
-			log.Info(text)
+			solve(&Challenge{Type: ChallengeDeviceLock, Message: "验证完成后继续....", URL: res.VerifyUrl})
 			continue
 		case client.OtherLoginError, client.UnknownLoginError:
 			msg := res.ErrorMessage
@@ -286,9 +264,11 @@ func (s *webServer) logincore(relogin bool) {
 				log.Fatalf("账号被冻结, 放弃重连")
 			}
 			log.Warnf("登录失败: %v", msg)
-			log.Infof("按 Enter 继续....")
-			readLine()
-			os.Exit(0)
+			if console && !s.headless {
+				log.Infof("按 Enter 继续....")
+				_, _ = stdinChallenge.r.ReadString('\n')
+			}
+			s.loginFailed()
 		}
 
 	Relogin:
@@ -297,6 +277,7 @@ func (s *webServer) logincore(relogin bool) {
 				log.Fatal("重连失败: 重连次数达到设置的上限值")
 				return
 			}
+			reloginAttempts.Inc("failed")
 			log.Warnf("将在 %v 秒后尝试重连. 重连次数：%v", s.Conf.ReLogin.ReLoginDelay, times)
 			times++
 			time.Sleep(time.Second * time.Duration(s.Conf.ReLogin.ReLoginDelay))
@@ -305,10 +286,19 @@ func (s *webServer) logincore(relogin bool) {
 		}
 	}
 	if relogin {
+		reloginAttempts.Inc("success")
 		log.Info("重连成功")
 	}
 }
 
+// loginFailed 放弃登录并退出
+func (s *webServer) loginFailed() {
+	if s.headless {
+		os.Exit(LoginFailedExitCode)
+	}
+	os.Exit(0)
+}
+
 // Dologin 主程序登录
 func (s *webServer) Dologin() {
 
@@ -316,6 +306,11 @@ func (s *webServer) Dologin() {
 	s.logincore(false)
 	log.Infof("登录成功 欢迎使用: %v", s.Cli.Nickname)
 	s.bot = coolq.NewQQBot(s.Cli, s.Conf)
+	if c := s.Conf.HealthCheck; c != nil {
+		coolq.Health.Concurrency = c.Concurrency
+		coolq.Health.RangeFetch = c.RangeFetch
+		coolq.Health.Start(s.bot, time.Minute*time.Duration(c.Interval))
+	}
 	if s.Conf.PostMessageFormat != "string" && s.Conf.PostMessageFormat != "array" {
 		log.Warnf("post_message_format 配置错误, 将自动使用 string")
 		coolq.SetMessageFormat("string")
@@ -331,17 +326,27 @@ func (s *webServer) Dologin() {
 	log.Info("アトリは、高性能ですから!")
 
 	s.Cli.OnDisconnected(func(q *client.QQClient, e *client.ClientDisconnectedEvent) {
+		if q != s.Cli { // 热重启后旧客户端的断线事件
+			return
+		}
+		s.bot.Publish(s.bot.Lifecycle(coolq.LifecycleDisable))
 		if !s.Conf.ReLogin.Enabled {
 			return
 		}
 		log.Warnf("Bot已离线 (%v)，尝试重连", e.Message)
 		s.logincore(true)
+		s.bot.Publish(s.bot.Lifecycle(coolq.LifecycleEnable))
 	})
 }
 
 func (s *webServer) admin(c *gin.Context) {
 	action := c.Param("action")
 	log.Debugf("WebServer接收到cgi调用: %v", action)
+	if scope := scopeFromContext(c); !scope.allow("admin/" + action) {
+		log.Warnf("已拒绝令牌 %v 调用Admin API %v: 权限不足", scope.name, action)
+		c.JSON(200, Failed(403, "令牌无权调用该API"))
+		return
+	}
 	if f, ok := APIAdminRoutingTable[action]; ok {
 		f(s, c)
 	} else {
@@ -376,6 +381,10 @@ func AuthMiddleWare() gin.HandlerFunc {
 			c.Next()
 			return
 		}
+		// 鉴权
+		if !checkAuth(c, newAuthenticator(conf)) {
+			return
+		}
 		// 处理请求
 		if c.Request.Method != "GET" && c.Request.Method != "POST" {
 			log.Warnf("已拒绝客户端 %v 的请求: 方法错误", c.Request.RemoteAddr)
@@ -396,26 +405,25 @@ func AuthMiddleWare() gin.HandlerFunc {
 			}
 			c.Set("json_body", gjson.ParseBytes(d))
 		}
-		authToken := conf.AccessToken
-		if auth := c.Request.Header.Get("Authorization"); auth != "" {
-			if strings.SplitN(auth, " ", 2)[1] != authToken {
-				c.AbortWithStatus(401)
-				return
-			}
-		} else if c.Query("access_token") != authToken {
-			c.AbortWithStatus(401)
-			return
-		} else {
-			c.Next()
-		}
+		c.Next()
 	}
 }
 
 func (s *webServer) DoReLogin() { // TODO: 协议层的 ReLogin
 	JSONConfig = nil
 	conf := GetConf()
-	OldConf := s.Conf
-	cli := client.NewClient(conf.Uin, conf.Password)
+	s.StopServer()
+	if s.bot != nil {
+		s.bot.Close()
+	}
+	if old := s.Cli; old != nil {
+		s.Cli = nil // 避免触发旧客户端的断线重连
+		old.Disconnect()
+	}
+	cli := client.NewClientMd5(conf.Uin, global.PasswordHash)
+	if conf.Password != "" {
+		cli = client.NewClient(conf.Uin, conf.Password)
+	}
 	log.Info("开始尝试登录并同步消息...")
 	log.Infof("使用协议: %v", func() string {
 		switch client.SystemDeviceInfo.Protocol {
@@ -449,54 +457,117 @@ func (s *webServer) DoReLogin() { // TODO: 协议层的 ReLogin
 		return true
 	})
 	s.Cli = cli
+	s.Conf = conf
 	s.Dologin()
-	// 关闭之前的 server
-	if OldConf.HTTPConfig != nil && OldConf.HTTPConfig.Enabled {
-		cqHTTPServer.ShutDown()
+	if err := s.UpServer(); err != nil {
+		log.Warnf("部分服务启动失败, 请检查配置后重试.")
 	}
-	// if OldConf.WSConfig != nil && OldConf.WSConfig.Enabled {
-	// 	server.WsShutdown()
-	// }
-	// s.UpServer()
-
-	s.ReloadServer()
-	s.Conf = conf
 }
 
-func (s *webServer) UpServer() {
+// UpServer 根据当前配置启动所有服务, 返回遇到的第一个错误
+func (s *webServer) UpServer() error {
 	conf := GetConf()
+	var services []service
 	if conf.HTTPConfig != nil && conf.HTTPConfig.Enabled {
-		go cqHTTPServer.Run(fmt.Sprintf("%s:%d", conf.HTTPConfig.Host, conf.HTTPConfig.Port), conf.AccessToken, s.bot)
+		hc := conf.HTTPConfig
+		if lc, err := newListenConfig(ListenAddr(hc.Host, uint64(hc.Port)), hc.UnixSocketMode, hc.TLSCert, hc.TLSKey, hc.TLSClientCA); err != nil {
+			log.Errorf("HTTP 服务器配置错误, HTTP 服务器将不会启动: %v", err)
+		} else {
+			services = append(services, newHTTPServer(lc, newAuthenticator(conf), s.bot))
+		}
 		for k, v := range conf.HTTPConfig.PostUrls {
-			newHTTPClient().Run(k, v, conf.HTTPConfig.Timeout, s.bot)
+			services = append(services, newHTTPClient(k, v, conf.HTTPConfig.Timeout, s.bot))
 		}
 	}
 	if conf.WSConfig != nil && conf.WSConfig.Enabled {
-		go WebSocketServer.Run(fmt.Sprintf("%s:%d", conf.WSConfig.Host, conf.WSConfig.Port), conf.AccessToken, s.bot)
+		wc := conf.WSConfig
+		if lc, err := newListenConfig(ListenAddr(wc.Host, uint64(wc.Port)), wc.UnixSocketMode, wc.TLSCert, wc.TLSKey, wc.TLSClientCA); err != nil {
+			log.Errorf("WebSocket 服务器配置错误, WebSocket 服务器将不会启动: %v", err)
+		} else {
+			services = append(services, newWebSocketServer(lc, newAuthenticator(conf), s.bot))
+		}
 	}
 	for _, rc := range conf.ReverseServers {
-		go NewWebSocketClient(rc, conf.AccessToken, s.bot).Run()
+		services = append(services, NewWebSocketClient(rc, conf.AccessToken, s.bot))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	var firstErr error
+	for _, svc := range services {
+		if err := svc.Start(ctx); err != nil {
+			log.Error(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		s.svcMutex.Lock()
+		s.services = append(s.services, svc)
+		s.svcMutex.Unlock()
 	}
+	s.bot.Publish(s.bot.Lifecycle(coolq.LifecycleEnable))
+	return firstErr
 }
 
-// 暂不支持ws服务的重启
-func (s *webServer) ReloadServer() {
-	conf := GetConf()
-	if conf.HTTPConfig != nil && conf.HTTPConfig.Enabled {
-		go cqHTTPServer.Run(fmt.Sprintf("%s:%d", conf.HTTPConfig.Host, conf.HTTPConfig.Port), conf.AccessToken, s.bot)
-		for k, v := range conf.HTTPConfig.PostUrls {
-			newHTTPClient().Run(k, v, conf.HTTPConfig.Timeout, s.bot)
+// StopServer 停止所有由 UpServer 启动的服务
+func (s *webServer) StopServer() {
+	if s.bot != nil && len(s.services) > 0 {
+		s.bot.Publish(s.bot.Lifecycle(coolq.LifecycleDisable))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = s.bot.FlushEvents(ctx)
+		cancel()
+	}
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	s.svcMutex.Lock()
+	services := s.services
+	s.services = nil
+	s.svcMutex.Unlock()
+	for _, svc := range services {
+		if err := svc.Stop(ctx); err != nil {
+			log.Warnf("停止服务时出现错误: %v", err)
 		}
 	}
-	for _, rc := range conf.ReverseServers {
-		go NewWebSocketClient(rc, conf.AccessToken, s.bot).Run()
+}
+
+// connectionStats 统计各服务的活跃连接数
+func (s *webServer) connectionStats() []connectionStat {
+	s.svcMutex.Lock()
+	services := s.services
+	s.svcMutex.Unlock()
+	r := make([]connectionStat, 0)
+	for _, svc := range services {
+		if cr, ok := svc.(connectionReporter); ok {
+			r = append(r, cr.connectionStats()...)
+		}
+	}
+	return r
+}
+
+// Shutdown 停止所有服务并断开连接, 用于进程退出前
+func (s *webServer) Shutdown() {
+	s.StopServer()
+	if cli := s.Cli; cli != nil && cli.Online {
+		s.Cli = nil // 避免触发断线重连
+		cli.Disconnect()
+	}
+}
+
+// ReloadServer 使用当前配置重启所有服务
+func (s *webServer) ReloadServer() {
+	s.StopServer()
+	if err := s.UpServer(); err != nil {
+		log.Warnf("部分服务启动失败, 请检查配置后重试.")
 	}
 }
 
 // AdminDoRestart 热重启
 func AdminDoRestart(s *webServer, c *gin.Context) {
-	s.bot = nil
-	s.Cli = nil
 	s.DoReLogin()
 	c.JSON(200, coolq.OK(coolq.MSG{}))
 }
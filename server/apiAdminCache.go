@@ -0,0 +1,60 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sam01101/gocq-qqdrive/coolq"
+	"github.com/sam01101/gocq-qqdrive/global"
+	log "github.com/sirupsen/logrus"
+)
+
+// AdminGetCacheStats 获取缓存目录统计
+func AdminGetCacheStats(s *webServer, c *gin.Context) {
+	stats, err := global.Cache.Stats()
+	if err != nil {
+		c.JSON(200, Failed(502, "读取缓存目录时出现错误: "+err.Error()))
+		return
+	}
+	c.JSON(200, coolq.OK(coolq.MSG{"cache": stats}))
+}
+
+// AdminCleanCache 清理缓存目录, all=true 时删除所有未使用的缓存文件
+func AdminCleanCache(s *webServer, c *gin.Context) {
+	r, err := global.Cache.Clean(adminParam(c, "all") == "true")
+	if err != nil {
+		log.Warnf("清理缓存目录时出现错误: %v", err)
+		c.JSON(200, Failed(502, "清理缓存目录时出现错误: "+err.Error()))
+		return
+	}
+	log.Infof("已清理 %v 个缓存文件, 共 %v 字节", r.Files, r.Bytes)
+	c.JSON(200, coolq.OK(coolq.MSG{"files": r.Files, "bytes": r.Bytes}))
+}
+
+// AdminMigrateCacheRecords 将旧格式的 .video 记录转换为最新格式
+func AdminMigrateCacheRecords(s *webServer, c *gin.Context) {
+	r, err := coolq.MigrateVideoRecords()
+	if err != nil {
+		c.JSON(200, Failed(502, "迁移视频记录时出现错误: "+err.Error()))
+		return
+	}
+	log.Infof("视频记录迁移完成: 共 %v 个, 已迁移 %v 个, 失败 %v 个", r.Total, r.Migrated, len(r.Failed))
+	c.JSON(200, coolq.OK(coolq.MSG{"total": r.Total, "migrated": r.Migrated, "failed": r.Failed}))
+}
+
+// AdminGetHealthReport 获取最近一次健康检查报告
+func AdminGetHealthReport(s *webServer, c *gin.Context) {
+	c.JSON(200, coolq.OK(coolq.MSG{"report": coolq.Health.Report()}))
+}
+
+// AdminDoHealthCheck 立即执行一次健康检查并返回报告
+func AdminDoHealthCheck(s *webServer, c *gin.Context) {
+	if s.bot == nil || s.Cli == nil || !s.Cli.Online {
+		c.JSON(200, Failed(503, "机器人未登录"))
+		return
+	}
+	r, err := coolq.Health.Run(c.Request.Context(), s.bot)
+	if err != nil {
+		c.JSON(200, Failed(502, "健康检查时出现错误: "+err.Error()))
+		return
+	}
+	c.JSON(200, coolq.OK(coolq.MSG{"report": r}))
+}
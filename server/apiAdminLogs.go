@@ -0,0 +1,108 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sam01101/gocq-qqdrive/coolq"
+	"github.com/sam01101/gocq-qqdrive/global"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminParam 读取 query 或表单参数
+func adminParam(c *gin.Context, k string) string {
+	if v := c.Query(k); v != "" {
+		return v
+	}
+	return c.PostForm(k)
+}
+
+// parseLogFilter 从请求参数解析日志过滤条件
+//
+// level 为最低日志等级, since/until 为Unix时间戳(秒), keyword 为子串, limit 为最多返回条数
+func parseLogFilter(c *gin.Context) (*global.LogFilter, error) {
+	f := &global.LogFilter{}
+	if lv := adminParam(c, "level"); lv != "" {
+		l, err := log.ParseLevel(lv)
+		if err != nil {
+			return nil, err
+		}
+		f.Level = l
+	}
+	for k, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := adminParam(c, k); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			*t = time.Unix(ts, 0)
+		}
+	}
+	f.Keyword = adminParam(c, "keyword")
+	f.Limit = 100
+	if v := adminParam(c, "limit"); v != "" {
+		f.Limit, _ = strconv.Atoi(v)
+	}
+	return f, nil
+}
+
+// AdminGetLogs 查询内存中的最近日志
+func AdminGetLogs(s *webServer, c *gin.Context) {
+	f, err := parseLogFilter(c)
+	if err != nil {
+		c.JSON(200, Failed(400, "参数错误: "+err.Error()))
+		return
+	}
+	c.JSON(200, coolq.OK(coolq.MSG{"logs": global.LogRing.Query(f)}))
+}
+
+// adminLogsStream 通过 WebSocket 实时推送日志
+func (s *webServer) adminLogsStream(c *gin.Context) {
+	if c.Param("action") != "logs" {
+		c.JSON(200, coolq.Failed(404))
+		return
+	}
+	if scope := scopeFromContext(c); !scope.allow("admin/get_logs") {
+		log.Warnf("已拒绝令牌 %v 订阅日志: 权限不足", scope.name)
+		c.JSON(200, Failed(403, "令牌无权调用该API"))
+		return
+	}
+	f, err := parseLogFilter(c)
+	if err != nil {
+		c.JSON(200, Failed(400, "参数错误: "+err.Error()))
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Warnf("处理日志订阅请求时出现错误: %v", err)
+		return
+	}
+	defer conn.Close()
+	ch, cancel := global.LogRing.Subscribe()
+	defer cancel()
+	closed := make(chan struct{})
+	go func() {
+		// 读取直到连接关闭
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-ch:
+			if !f.Match(&e) {
+				continue
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
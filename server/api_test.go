@@ -0,0 +1,41 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestAPIParamsComplete(t *testing.T) {
+	for action := range API {
+		if _, ok := apiParams[action]; !ok {
+			t.Errorf("missing parameter description for %v", action)
+		}
+	}
+	for action := range apiParams {
+		if _, ok := API[action]; !ok {
+			t.Errorf("parameter description for unknown action %v", action)
+		}
+	}
+}
+
+func TestCallAPIMetrics(t *testing.T) {
+	api := &apiCaller{}
+	if ret := api.callAPI(context.Background(), "no_such_action", nil); ret["retcode"] != 404 {
+		t.Fatalf("callAPI() = %v", ret)
+	}
+	var buf bytes.Buffer
+	global.WriteMetrics(&buf)
+	for _, want := range []string{
+		`gocq_api_calls_total{action="unknown",retcode="404"} `,
+		`gocq_api_call_duration_seconds_bucket{action="unknown",retcode="404",le="+Inf"} `,
+		`gocq_api_call_duration_seconds_count{action="unknown",retcode="404"} `,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/sam01101/gocq-qqdrive/global"
+	log "github.com/sirupsen/logrus"
+)
+
+// authenticator 对来自客户端的请求进行鉴权, 支持 access_token, 附加令牌与 HMAC 请求签名三种方式
+type authenticator struct {
+	token  string
+	sign   *global.GoCQRequestSignConfig
+	tokens []*global.GoCQAPIToken
+}
+
+// tokenScope 附加令牌的权限范围, 为nil时表示拥有全部权限
+type tokenScope struct {
+	name    string
+	actions []string
+}
+
+// nonceCache 记录窗口期内已使用过的 nonce, 用于防止签名请求被重放
+type nonceCache struct {
+	lock      sync.Mutex
+	seen      map[string]int64
+	lastPrune int64
+}
+
+var (
+	errTokenMismatch    = errors.New("token mismatch")
+	errBadAuthorization = errors.New("malformed authorization header")
+	errBadSignature     = errors.New("signature mismatch")
+	errSignExpired      = errors.New("timestamp out of window")
+	errNonceReused      = errors.New("nonce reused")
+	errTokenExpired     = errors.New("token expired")
+	errIPNotAllowed     = errors.New("remote address not allowed")
+
+	nonces = &nonceCache{seen: map[string]int64{}}
+)
+
+func newAuthenticator(conf *global.JSONConfig) *authenticator {
+	return &authenticator{token: conf.AccessToken, sign: conf.RequestSign, tokens: conf.Tokens}
+}
+
+func (a *authenticator) signEnabled() bool {
+	return a.sign != nil && a.sign.Enabled && a.sign.Secret != ""
+}
+
+// enabled 是否需要对请求进行鉴权
+func (a *authenticator) enabled() bool {
+	return a.token != "" || a.signEnabled() || len(a.tokens) > 0
+}
+
+// authorize 校验请求r, body为请求体原文(没有则为nil)
+//
+// 通过 access_token 或签名鉴权的请求拥有全部权限, 返回的 *tokenScope 为nil
+func (a *authenticator) authorize(r *http.Request, body []byte) (*tokenScope, error) {
+	if !a.enabled() {
+		return nil, nil
+	}
+	if a.signEnabled() && headerOrQuery(r, "X-Signature", "signature") != "" {
+		return nil, a.verifySignature(r, body)
+	}
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		var ok bool
+		if token, ok = parseAuthorization(auth); !ok {
+			return nil, errBadAuthorization
+		}
+	}
+	if token == "" {
+		return nil, errTokenMismatch
+	}
+	if a.token != "" && tokenEqual(token, a.token) {
+		return nil, nil
+	}
+	for _, t := range a.tokens {
+		if t.Token == "" || !tokenEqual(token, t.Token) {
+			continue
+		}
+		if t.ExpireAt > 0 && time.Now().Unix() > t.ExpireAt {
+			return nil, errTokenExpired
+		}
+		// unix socket 连接没有来源IP, 访问权限由 socket 文件权限控制
+		if len(t.AllowIPs) > 0 && !isUnixRequest(r) && !ipAllowed(r.RemoteAddr, t.AllowIPs) {
+			return nil, errIPNotAllowed
+		}
+		return &tokenScope{name: t.Name, actions: t.Actions}, nil
+	}
+	return nil, errTokenMismatch
+}
+
+// allow 判断该令牌是否允许调用action, Admin API 的 action 以 "admin/" 开头
+func (s *tokenScope) allow(action string) bool {
+	if s == nil {
+		return true
+	}
+	for _, pattern := range s.actions {
+		if ok, _ := path.Match(pattern, action); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed 判断remoteAddr是否在给定的IP或网段列表中
+func ipAllowed(remoteAddr string, allow []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, a := range allow {
+		if _, network, err := net.ParseCIDR(a); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature 校验签名 sha256=HEX(HMAC-SHA256(secret, method + "\n" + path + "\n" + query + "\n" + timestamp + "\n" + nonce + "\n" + body))
+func (a *authenticator) verifySignature(r *http.Request, body []byte) error {
+	signature := headerOrQuery(r, "X-Signature", "signature")
+	timestamp := headerOrQuery(r, "X-Timestamp", "timestamp")
+	nonce := headerOrQuery(r, "X-Nonce", "nonce")
+	if !strings.HasPrefix(signature, "sha256=") || timestamp == "" || nonce == "" {
+		return errBadSignature
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	window := a.sign.Window
+	if window <= 0 {
+		window = 300
+	}
+	now := time.Now().Unix()
+	if ts < now-window || ts > now+window {
+		return errSignExpired
+	}
+	expected, err := hex.DecodeString(signature[len("sha256="):])
+	if err != nil {
+		return errBadSignature
+	}
+	mac := hmac.New(sha256.New, []byte(a.sign.Secret))
+	mac.Write([]byte(r.Method + "\n" + r.URL.Path + "\n" + signedQuery(r.URL.RawQuery) + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errBadSignature
+	}
+	if !nonces.use(nonce, now, window) {
+		return errNonceReused
+	}
+	return nil
+}
+
+// use 标记nonce已被使用, 若nonce在窗口期内已出现过则返回false
+func (c *nonceCache) use(nonce string, now, window int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if now != c.lastPrune {
+		for k, exp := range c.seen {
+			if exp < now {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	if exp, ok := c.seen[nonce]; ok && exp >= now {
+		return false
+	}
+	// 时间戳最多可偏移一个窗口, 因此 nonce 需要保留两个窗口
+	c.seen[nonce] = now + 2*window
+	return true
+}
+
+// parseAuthorization 解析 Authorization 头, 格式为 "<Scheme> <Token>"
+func parseAuthorization(auth string) (string, bool) {
+	auth = strings.TrimSpace(auth)
+	i := strings.IndexAny(auth, " \t")
+	if i <= 0 {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[i+1:])
+	if token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func tokenEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// signedQuery 返回参与签名的 query, 即去掉 signature 参数后的原始 query
+func signedQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	params := strings.Split(raw, "&")
+	kept := params[:0]
+	for _, p := range params {
+		if p != "signature" && !strings.HasPrefix(p, "signature=") {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func headerOrQuery(r *http.Request, header, query string) string {
+	if v := r.Header.Get(header); v != "" {
+		return v
+	}
+	return r.URL.Query().Get(query)
+}
+
+// readBody 读取请求体并将其重新放回请求中, 以便后续处理函数继续读取
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	_ = r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// checkAuth 对gin请求进行鉴权, 失败时中止请求并返回false
+//
+// 鉴权通过后令牌的权限范围将保存在 gin.Context 的 "token_scope" 中
+func checkAuth(c *gin.Context, auth *authenticator) bool {
+	if c.Request.Method == "OPTIONS" || !auth.enabled() {
+		return true
+	}
+	body, err := readBody(c.Request)
+	if err != nil {
+		log.Warnf("获取请求 %v 的Body时出现错误: %v", c.Request.RequestURI, err)
+		c.AbortWithStatus(400)
+		return false
+	}
+	scope, err := auth.authorize(c.Request, body)
+	if err != nil {
+		log.Warnf("已拒绝客户端 %v 的请求: 鉴权失败 (%v)", c.Request.RemoteAddr, err)
+		c.AbortWithStatus(401)
+		return false
+	}
+	if scope != nil {
+		c.Set("token_scope", scope)
+	}
+	return true
+}
+
+// scopeFromContext 获取 checkAuth 保存的令牌权限范围
+func scopeFromContext(c *gin.Context) *tokenScope {
+	if s, ok := c.Get("token_scope"); ok {
+		return s.(*tokenScope)
+	}
+	return nil
+}
+
+// authMiddleware HTTP API 使用的鉴权中间件
+func authMiddleware(auth *authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if checkAuth(c, auth) {
+			c.Next()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestParseAuthorization(t *testing.T) {
+	for auth, want := range map[string]string{
+		"Bearer abc":  "abc",
+		"Token  abc ": "abc",
+		"abc":         "",
+		"Bearer ":     "",
+		"":            "",
+	} {
+		if got, _ := parseAuthorization(auth); got != want {
+			t.Errorf("parseAuthorization(%q) = %q, want %q", auth, got, want)
+		}
+	}
+}
+
+func TestAuthenticatorSignature(t *testing.T) {
+	a := newAuthenticator(&global.JSONConfig{RequestSign: &global.GoCQRequestSignConfig{Enabled: true, Secret: "secret", Window: 60}})
+	body := `{"action":"get_login_info"}`
+	sign := func(method, path, query, ts, nonce string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(method + "\n" + path + "\n" + query + "\n" + ts + "\n" + nonce + "\n" + body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	request := func(method, target, ts, nonce, signature string) error {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("X-Timestamp", ts)
+		r.Header.Set("X-Nonce", nonce)
+		r.Header.Set("X-Signature", signature)
+		_, err := a.authorize(r, []byte(body))
+		return err
+	}
+	// 已使用的 nonce 在进程内全局记录, 加上前缀以便重复运行测试
+	n := func(s string) string { return strconv.FormatInt(time.Now().UnixNano(), 36) + s }
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	n1 := n("n1")
+	if err := request("POST", "/get_login_info?a=1&b=2", now, n1, sign("POST", "/get_login_info", "a=1&b=2", now, n1)); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := request("POST", "/get_login_info?a=1&b=2", now, n1, sign("POST", "/get_login_info", "a=1&b=2", now, n1)); err != errNonceReused {
+		t.Fatalf("replayed nonce: got %v", err)
+	}
+	n2 := n("n2")
+	if err := request("POST", "/get_login_info", now, n2, sign("POST", "/get_login_info", "", now, n("n3"))); err != errBadSignature {
+		t.Fatalf("bad signature: got %v", err)
+	}
+	// 签名覆盖请求方法, 路径与 query
+	for _, target := range []struct{ method, url string }{
+		{"GET", "/get_login_info"},
+		{"POST", "/admin/do_restart"},
+		{"POST", "/get_login_info?a=2"},
+	} {
+		nonce := n(target.method + target.url)
+		if err := request(target.method, target.url, now, nonce, sign("POST", "/get_login_info", "", now, nonce)); err != errBadSignature {
+			t.Fatalf("%v %v with signature of another request: got %v", target.method, target.url, err)
+		}
+	}
+	// query 中的 signature 参数不参与签名
+	n5 := n("n5")
+	target := "/get_login_info?a=1&signature=" + sign("POST", "/get_login_info", "a=1", now, n5)
+	if err := request("POST", target, now, n5, sign("POST", "/get_login_info", "a=1", now, n5)); err != nil {
+		t.Fatalf("signature in query rejected: %v", err)
+	}
+	old := strconv.FormatInt(time.Now().Unix()-120, 10)
+	n4 := n("n4")
+	if err := request("POST", "/get_login_info", old, n4, sign("POST", "/get_login_info", "", old, n4)); err != errSignExpired {
+		t.Fatalf("expired timestamp: got %v", err)
+	}
+	r := httptest.NewRequest("GET", "/get_login_info", nil)
+	r.Header.Set("Authorization", "Bearer")
+	if _, err := a.authorize(r, nil); err == nil {
+		t.Fatal("unsigned request accepted")
+	}
+}
+
+func TestAuthenticatorScopedToken(t *testing.T) {
+	a := newAuthenticator(&global.JSONConfig{
+		AccessToken: "master",
+		Tokens: []*global.GoCQAPIToken{
+			{Name: "readonly", Token: "ro", Actions: []string{"get_*"}, AllowIPs: []string{"10.0.0.0/8"}},
+			{Name: "expired", Token: "old", Actions: []string{"*"}, ExpireAt: 1},
+		},
+	})
+	request := func(token, remote string) (*tokenScope, error) {
+		r := httptest.NewRequest("GET", "/get_login_info?access_token="+token, nil)
+		r.RemoteAddr = remote
+		return a.authorize(r, nil)
+	}
+	if scope, err := request("master", "1.1.1.1:80"); err != nil || scope != nil {
+		t.Fatalf("master token: scope=%v err=%v", scope, err)
+	}
+	scope, err := request("ro", "10.1.2.3:80")
+	if err != nil {
+		t.Fatalf("scoped token rejected: %v", err)
+	}
+	if !scope.allow("get_forward_msg") || scope.allow("upload_short_video") || scope.allow("admin/get_config_json") {
+		t.Fatal("scoped token permissions mismatch")
+	}
+	if _, err = request("ro", "1.1.1.1:80"); err != errIPNotAllowed {
+		t.Fatalf("ip allowlist: got %v", err)
+	}
+	if _, err = request("old", "1.1.1.1:80"); err != errTokenExpired {
+		t.Fatalf("expired token: got %v", err)
+	}
+}
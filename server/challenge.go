@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	asciiart "github.com/yinghau76/go-ascii-art"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+// 登录验证类型
+const (
+	ChallengeSliderMethod = "slider_method" // 选择滑块验证码的处理方式 1-3
+	ChallengeTicket       = "ticket"        // 滑块验证码的 Ticket, url 为验证地址
+	ChallengeSlider       = "slider"        // 自动处理滑块验证码并返回 Ticket, id 为滑块ID
+	ChallengeCaptcha      = "captcha"       // 图片验证码, image 为验证码图片
+	ChallengeSMS          = "sms"           // 短信验证码, phone 为接收短信的手机号
+	ChallengeVerifyMethod = "verify_method" // 选择设备锁验证方式 1-2
+	ChallengeDeviceLock   = "device_lock"   // 前往 url 完成设备锁验证后提交任意内容继续
+)
+
+// ErrChallengeTimeout 等待登录验证输入超时
+var ErrChallengeTimeout = errors.New("login challenge timeout")
+
+// Challenge 登录时需要外部处理的验证
+type Challenge struct {
+	Type    string `json:"type"`
+	SelfID  int64  `json:"self_id"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Image   []byte `json:"image,omitempty"` // JSON 中为 base64
+}
+
+// ChallengeProvider 登录验证的处理方式
+type ChallengeProvider interface {
+	// Solve 展示验证信息并等待输入
+	Solve(c *Challenge) (string, error)
+}
+
+// newChallengeProvider 根据配置选择登录验证的处理方式
+func newChallengeProvider(conf *global.JSONConfig) ChallengeProvider {
+	c := conf.LoginChallenge
+	if c == nil {
+		c = &global.GoCQLoginChallengeConfig{}
+	}
+	timeout := time.Second * time.Duration(c.Timeout)
+	web := ""
+	if conf.WebUI != nil {
+		web = ListenAddr(conf.WebUI.Host, conf.WebUI.WebUIPort)
+	}
+	provider := c.Provider
+	if provider == "" {
+		provider = "console"
+		if conf.WebUI != nil && conf.WebUI.WebInput {
+			provider = "web"
+		}
+	}
+	switch provider {
+	case "web":
+		return &webChallenge{addr: web, timeout: timeout}
+	case "webhook":
+		return &webhookChallenge{url: c.WebhookURL, web: &webChallenge{addr: web, timeout: timeout}}
+	case "file":
+		dir := c.Dir
+		if dir == "" {
+			dir = "data/challenge"
+		}
+		return &fileChallenge{dir: dir, timeout: timeout, interval: time.Second}
+	case "console":
+	default:
+		log.Warnf("未知的登录验证方式 %v, 将使用 console", provider)
+	}
+	return stdinChallenge
+}
+
+// consoleChallenge 从控制台读取输入
+type consoleChallenge struct {
+	r *bufio.Reader
+}
+
+var stdinChallenge = &consoleChallenge{r: bufio.NewReader(os.Stdin)}
+
+func (p *consoleChallenge) Solve(c *Challenge) (string, error) {
+	if c.Type == ChallengeSlider {
+		return sliderTicket(c)
+	}
+	if c.Type == ChallengeCaptcha {
+		if img, _, err := image.Decode(bytes.NewReader(c.Image)); err == nil {
+			fmt.Println(asciiart.New("image", img).Art)
+		}
+	}
+	log.Warnf("%v (Enter 提交)", c.Message)
+	text, err := p.r.ReadString('\n')
+	if err != nil && text == "" {
+		return "", err
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// webChallenge 等待 Admin API do_web_write 的输入
+type webChallenge struct {
+	addr    string
+	timeout time.Duration
+}
+
+func (p *webChallenge) Solve(c *Challenge) (string, error) {
+	if c.Type == ChallengeSlider {
+		return sliderTicket(c)
+	}
+	log.Warnf("%v (http://%v/admin/do_web_write 输入)", c.Message, p.addr)
+	var timeout <-chan time.Time
+	if p.timeout > 0 {
+		timeout = time.After(p.timeout)
+	}
+	select {
+	case text := <-WebInput:
+		return strings.TrimSpace(text), nil
+	case <-timeout:
+		return "", ErrChallengeTimeout
+	}
+}
+
+// sliderTicket 使用Cef工具的中转服务处理滑块验证码, 供 console 与 web 使用
+var sliderTicket = func(c *Challenge) (string, error) {
+	log.Warnf("滑块ID为 %v 请在30S内处理.", c.ID)
+	return global.GetSliderTicket(c.URL, c.ID)
+}
+
+// webhookChallenge 将验证信息以 JSON 格式 POST 到 url, 响应中包含 answer 时直接使用, 否则等待 do_web_write 输入
+type webhookChallenge struct {
+	url string
+	web *webChallenge
+}
+
+func (p *webhookChallenge) Solve(c *Challenge) (string, error) {
+	if p.url == "" {
+		return "", errors.New("webhook_url is empty")
+	}
+	body, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	if p.web.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.web.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	log.Infof("已将登录验证 %v 发送到 %v", c.Type, p.url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("webhook status %v", resp.Status)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if answer := gjson.GetBytes(b, "answer"); answer.Exists() {
+		return strings.TrimSpace(answer.String()), nil
+	}
+	return p.web.Solve(c)
+}
+
+// fileChallenge 将验证信息写入 dir/challenge.json, 验证码图片写入 dir/captcha.jpg, 等待 dir/answer.txt 出现
+type fileChallenge struct {
+	dir      string
+	timeout  time.Duration
+	interval time.Duration
+}
+
+func (p *fileChallenge) Solve(c *Challenge) (string, error) {
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return "", err
+	}
+	var (
+		challenge = filepath.Join(p.dir, "challenge.json")
+		captcha   = filepath.Join(p.dir, "captcha.jpg")
+		answer    = filepath.Join(p.dir, "answer.txt")
+	)
+	_ = os.Remove(answer)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(challenge, b, 0644); err != nil {
+		return "", err
+	}
+	defer os.Remove(challenge)
+	if len(c.Image) > 0 {
+		if err = ioutil.WriteFile(captcha, c.Image, 0644); err != nil {
+			return "", err
+		}
+		defer os.Remove(captcha)
+	}
+	log.Warnf("%v (已写入 %v, 请将输入写入 %v)", c.Message, challenge, answer)
+	deadline := time.Now().Add(p.timeout)
+	for {
+		if text, err := ioutil.ReadFile(answer); err == nil {
+			_ = os.Remove(answer)
+			return strings.TrimSpace(string(text)), nil
+		}
+		if p.timeout > 0 && time.Now().After(deadline) {
+			return "", ErrChallengeTimeout
+		}
+		time.Sleep(p.interval)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestNewChallengeProvider(t *testing.T) {
+	for _, c := range []struct {
+		conf *global.JSONConfig
+		want string
+	}{
+		{&global.JSONConfig{}, "*server.consoleChallenge"},
+		{&global.JSONConfig{WebUI: &global.GoCQWebUI{WebInput: true}}, "*server.webChallenge"},
+		{&global.JSONConfig{LoginChallenge: &global.GoCQLoginChallengeConfig{Provider: "file"}}, "*server.fileChallenge"},
+		{&global.JSONConfig{LoginChallenge: &global.GoCQLoginChallengeConfig{Provider: "webhook"}}, "*server.webhookChallenge"},
+	} {
+		if got := fmt.Sprintf("%T", newChallengeProvider(c.conf)); got != c.want {
+			t.Errorf("newChallengeProvider() = %v, want %v", got, c.want)
+		}
+	}
+}
+
+func TestConsoleChallenge(t *testing.T) {
+	p := &consoleChallenge{r: bufio.NewReader(strings.NewReader(" 1234 \n"))}
+	if text, err := p.Solve(&Challenge{Type: ChallengeSMS}); err != nil || text != "1234" {
+		t.Fatalf("Solve() = %q, %v", text, err)
+	}
+	if _, err := p.Solve(&Challenge{Type: ChallengeSMS}); err == nil {
+		t.Fatal("Solve() on closed input should fail")
+	}
+}
+
+func TestWebChallengeTimeout(t *testing.T) {
+	p := &webChallenge{timeout: time.Millisecond * 10}
+	if _, err := p.Solve(&Challenge{Type: ChallengeTicket}); err != ErrChallengeTimeout {
+		t.Fatalf("Solve() error = %v, want %v", err, ErrChallengeTimeout)
+	}
+}
+
+func TestWebhookChallenge(t *testing.T) {
+	var got Challenge
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"answer":" ticket "}`))
+	}))
+	defer ts.Close()
+	p := &webhookChallenge{url: ts.URL, web: &webChallenge{timeout: time.Second}}
+	text, err := p.Solve(&Challenge{Type: ChallengeTicket, SelfID: 10000, URL: "https://example.com/verify"})
+	if err != nil || text != "ticket" {
+		t.Fatalf("Solve() = %q, %v", text, err)
+	}
+	if got.Type != ChallengeTicket || got.SelfID != 10000 || got.URL != "https://example.com/verify" {
+		t.Fatalf("webhook received %+v", got)
+	}
+}
+
+func TestFileChallenge(t *testing.T) {
+	dir := t.TempDir()
+	p := &fileChallenge{dir: dir, timeout: time.Second * 5, interval: time.Millisecond * 10}
+	go func() {
+		challenge := filepath.Join(dir, "challenge.json")
+		for !global.PathExists(challenge) {
+			time.Sleep(time.Millisecond * 5)
+		}
+		if !global.PathExists(filepath.Join(dir, "captcha.jpg")) {
+			t.Error("captcha.jpg not written")
+		}
+		_ = ioutil.WriteFile(filepath.Join(dir, "answer.txt"), []byte("abcd\n"), 0644)
+	}()
+	text, err := p.Solve(&Challenge{Type: ChallengeCaptcha, Image: []byte{0xff, 0xd8}})
+	if err != nil || text != "abcd" {
+		t.Fatalf("Solve() = %q, %v", text, err)
+	}
+	for _, f := range []string{"challenge.json", "captcha.jpg", "answer.txt"} {
+		if global.PathExists(filepath.Join(dir, f)) {
+			t.Errorf("%v not removed", f)
+		}
+	}
+}
+
+func TestSliderChallenge(t *testing.T) {
+	old := sliderTicket
+	defer func() { sliderTicket = old }()
+	sliderTicket = func(c *Challenge) (string, error) { return "cef-" + c.ID, nil }
+	c := &Challenge{Type: ChallengeSlider, URL: "https://example.com/slider", ID: "123456"}
+	for _, p := range []ChallengeProvider{&consoleChallenge{r: bufio.NewReader(strings.NewReader(""))}, &webChallenge{timeout: time.Millisecond}} {
+		if text, err := p.Solve(c); err != nil || text != "cef-123456" {
+			t.Fatalf("%T.Solve() = %q, %v", p, text, err)
+		}
+	}
+
+	// webhook 由外部工具处理, 不使用Cef中转服务
+	var got Challenge
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"answer":"own-ticket"}`))
+	}))
+	defer ts.Close()
+	p := &webhookChallenge{url: ts.URL, web: &webChallenge{timeout: time.Second}}
+	if text, err := p.Solve(c); err != nil || text != "own-ticket" || got.Type != ChallengeSlider || got.ID != "123456" {
+		t.Fatalf("Solve() = %q, %v, webhook received %+v", text, err, got)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -15,11 +14,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/guonaihong/gout"
 	"github.com/guonaihong/gout/dataflow"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 )
 
 type httpServer struct {
+	lc     *listenConfig
+	auth   *authenticator
 	engine *gin.Engine
 	bot    *coolq.CQBot
 	HTTP   *http.Server
@@ -30,23 +32,31 @@ type httpClient struct {
 	bot     *coolq.CQBot
 	secret  string
 	addr    string
+	url     string
+	client  *http.Client
 	timeout int32
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sub     *coolq.Subscription
 }
 
 type httpContext struct {
 	ctx *gin.Context
 }
 
-var cqHTTPServer = &httpServer{}
-
 // Debug 是否启用Debug模式
 var Debug = false
 
-func (s *httpServer) Run(addr, authToken string, bot *coolq.CQBot) {
+func newHTTPServer(lc *listenConfig, auth *authenticator, bot *coolq.CQBot) *httpServer {
+	return &httpServer{lc: lc, auth: auth, bot: bot}
+}
+
+// Start 开始监听并处理HTTP API请求
+func (s *httpServer) Start(_ context.Context) error {
 	gin.SetMode(gin.ReleaseMode)
 	s.engine = gin.New()
-	s.bot = bot
-	s.api = apiCaller{s.bot}
+	s.api = apiCaller{bot: s.bot}
+	s.engine.Use(authMiddleware(s.auth))
 	s.engine.Use(func(c *gin.Context) {
 		if c.Request.Method != "GET" && c.Request.Method != "POST" {
 			log.Warnf("已拒绝客户端 %v 的请求: 方法错误", c.Request.RemoteAddr)
@@ -70,59 +80,68 @@ func (s *httpServer) Run(addr, authToken string, bot *coolq.CQBot) {
 		c.Next()
 	})
 
-	if authToken != "" {
-		s.engine.Use(func(c *gin.Context) {
-			if auth := c.Request.Header.Get("Authorization"); auth != "" {
-				if strings.SplitN(auth, " ", 2)[1] != authToken {
-					c.AbortWithStatus(401)
-					return
-				}
-			} else if c.Query("access_token") != authToken {
-				c.AbortWithStatus(401)
-				return
-			} else {
-				c.Next()
-			}
-		})
-	}
-
 	s.engine.Any("/:action", s.HandleActions)
 
+	ln, err := listen(s.lc)
+	if err != nil {
+		return errors.Wrapf(err, "CQ HTTP 服务器 %v 启动失败", s.lc)
+	}
+	s.HTTP = &http.Server{
+		Addr:    s.lc.addr,
+		Handler: s.engine,
+	}
+	log.Infof("CQ HTTP 服务器已启动: %v", s.lc)
 	go func() {
-		log.Infof("CQ HTTP 服务器已启动: %v", addr)
-		s.HTTP = &http.Server{
-			Addr:    addr,
-			Handler: s.engine,
-		}
-		if err := s.HTTP.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Error(err)
-			log.Infof("HTTP 服务启动失败, 请检查端口是否被占用.")
-			log.Warnf("将在五秒后退出.")
-			time.Sleep(time.Second * 5)
-			os.Exit(1)
+		if err := s.HTTP.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("CQ HTTP 服务器运行时出现错误: %v", err)
 		}
 	}()
+	return nil
 }
 
-func newHTTPClient() *httpClient {
-	return &httpClient{}
+// Stop 停止接受新请求, 并等待正在处理的请求完成
+func (s *httpServer) Stop(ctx context.Context) error {
+	if s.HTTP == nil {
+		return nil
+	}
+	err := s.HTTP.Shutdown(ctx)
+	log.Infof("CQ HTTP 服务器已停止: %v", s.lc)
+	return err
 }
 
-func (c *httpClient) Run(addr, secret string, timeout int32, bot *coolq.CQBot) {
-	c.bot = bot
-	c.secret = secret
-	c.addr = addr
-	c.timeout = timeout
+func newHTTPClient(addr, secret string, timeout int32, bot *coolq.CQBot) *httpClient {
+	c := &httpClient{bot: bot, secret: secret, addr: addr, url: addr, timeout: timeout}
+	if sock, reqPath, ok := parseUnixURL(addr); ok {
+		c.url = "http://unix" + reqPath
+		c.client = unixHTTPClient(sock)
+	}
 	if c.timeout < 5 {
 		c.timeout = 5
 	}
-	bot.OnEventPush(c.onBotPushEvent)
-	log.Infof("HTTP POST上报器已启动: %v", addr)
+	return c
+}
+
+// Start 开始向addr上报事件
+func (c *httpClient) Start(ctx context.Context) error {
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.sub = c.bot.Subscribe("http_post:"+c.addr, c.onBotPushEvent)
+	log.Infof("HTTP POST上报器已启动: %v", c.addr)
+	return nil
+}
+
+// Stop 停止上报事件, 正在进行的上报将被取消
+func (c *httpClient) Stop(_ context.Context) error {
+	c.sub.Unsubscribe()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	log.Infof("HTTP POST上报器已停止: %v", c.addr)
+	return nil
 }
 
 func (c *httpClient) onBotPushEvent(m coolq.MSG) {
 	var res string
-	err := gout.POST(c.addr).SetJSON(m).BindBody(&res).SetHeader(func() gout.H {
+	err := gout.New(c.client).POST(c.url).WithContext(c.ctx).SetJSON(m).BindBody(&res).SetHeader(func() gout.H {
 		h := gout.H{
 			"X-Self-ID":  c.bot.Client.Uin,
 			"User-Agent": "CQHttp/4.15.0",
@@ -146,6 +165,7 @@ func (c *httpClient) onBotPushEvent(m coolq.MSG) {
 			}
 			return nil
 		}).Do()
+	eventPushes.Inc("http_post:"+c.addr, global.ResultLabel(err))
 	if err != nil {
 		log.Warnf("上报Event数据 %v 到 %v 失败: %v", m.ToJSON(), c.addr, err)
 		return
@@ -154,10 +174,21 @@ func (c *httpClient) onBotPushEvent(m coolq.MSG) {
 }
 
 func (s *httpServer) HandleActions(c *gin.Context) {
-	global.RateLimit(context.Background())
+	reqID := c.GetHeader("X-Request-ID")
+	if reqID == "" {
+		reqID = coolq.NewRequestID()
+	}
+	c.Header("X-Request-ID", reqID)
+	ctx := coolq.WithLogFields(c.Request.Context(), log.Fields{
+		"request_id":  reqID,
+		"remote_addr": c.Request.RemoteAddr,
+	})
+	global.RateLimit(ctx)
 	action := strings.ReplaceAll(c.Param("action"), "_async", "")
-	log.Debugf("HTTPServer接收到API调用: %v", action)
-	c.JSON(200, s.api.callAPI(action, httpContext{ctx: c}))
+	coolq.Logger(ctx).Debugf("HTTPServer接收到API调用: %v", action)
+	api := s.api
+	api.scope = scopeFromContext(c)
+	c.JSON(200, api.callAPI(ctx, action, httpContext{ctx: c}))
 }
 
 func (h httpContext) Get(k string) gjson.Result {
@@ -181,14 +212,3 @@ func (h httpContext) Get(k string) gjson.Result {
 	}
 	return gjson.Result{Type: gjson.Null, Str: ""}
 }
-
-func (s *httpServer) ShutDown() {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := s.HTTP.Shutdown(ctx); err != nil {
-		log.Fatal("http Server Shutdown:", err)
-	}
-	<-ctx.Done()
-	log.Println("timeout of 5 seconds.")
-	log.Println("http Server exiting")
-}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+// unixPrefix unix socket 地址前缀, 如 unix:///run/gocq/api.sock
+const unixPrefix = "unix://"
+
+// listenConfig 服务端监听配置
+type listenConfig struct {
+	addr string      // host:port 或 unix:///path/to.sock
+	mode os.FileMode // unix socket 文件权限, 为0时不修改
+	tls  *tls.Config
+}
+
+// ListenAddr 根据配置的host与port生成监听地址, host为 unix:// 地址时忽略port
+func ListenAddr(host string, port uint64) string {
+	if strings.HasPrefix(host, unixPrefix) {
+		return host
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// newListenConfig 根据配置生成监听配置, mode 为八进制的 unix socket 文件权限
+func newListenConfig(addr, mode, cert, key, clientCA string) (*listenConfig, error) {
+	lc := &listenConfig{addr: addr}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid unix socket mode")
+		}
+		lc.mode = os.FileMode(m)
+	}
+	tlsConfig, err := global.NewServerTLSConfig(cert, key, clientCA)
+	if err != nil {
+		return nil, err
+	}
+	lc.tls = tlsConfig
+	return lc, nil
+}
+
+func (lc *listenConfig) String() string {
+	if lc.tls != nil {
+		return lc.addr + " (TLS)"
+	}
+	return lc.addr
+}
+
+// listen 按监听配置开启监听
+func listen(lc *listenConfig) (net.Listener, error) {
+	var ln net.Listener
+	var err error
+	if strings.HasPrefix(lc.addr, unixPrefix) {
+		ln, err = listenUnix(strings.TrimPrefix(lc.addr, unixPrefix), lc.mode)
+	} else {
+		ln, err = net.Listen("tcp", lc.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lc.tls != nil {
+		ln = tls.NewListener(ln, lc.tls)
+	}
+	return ln, nil
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	// 清理上次运行残留的 socket 文件
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err = os.Chmod(path, mode); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// isUnixRequest 判断请求是否来自 unix socket 监听
+func isUnixRequest(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// parseUnixURL 解析 unix:///path/to.sock[:/request/path] 格式的地址
+//
+// 返回 socket 路径与请求路径, 请求路径默认为 "/"
+func parseUnixURL(raw string) (sock, reqPath string, ok bool) {
+	if !strings.HasPrefix(raw, unixPrefix) {
+		return "", "", false
+	}
+	sock = strings.TrimPrefix(raw, unixPrefix)
+	reqPath = "/"
+	if i := strings.Index(sock, ":/"); i >= 0 {
+		sock, reqPath = sock[:i], sock[i+1:]
+	}
+	return sock, reqPath, sock != ""
+}
+
+// unixDialContext 返回一个总是连接到给定 unix socket 的 DialContext
+func unixDialContext(sock string) func(ctx context.Context, _, _ string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", sock)
+	}
+}
+
+// unixHTTPClient 生成通过 unix socket 发送请求的 http.Client
+func unixHTTPClient(sock string) *http.Client {
+	return &http.Client{Transport: &http.Transport{DialContext: unixDialContext(sock)}}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestParseUnixURL(t *testing.T) {
+	for _, c := range []struct {
+		raw, sock, path string
+		ok              bool
+	}{
+		{"unix:///run/gocq/ws.sock", "/run/gocq/ws.sock", "/", true},
+		{"unix:///run/gocq/ws.sock:/event", "/run/gocq/ws.sock", "/event", true},
+		{"unix:///run/gocq/ws.sock:/a/b?c=d", "/run/gocq/ws.sock", "/a/b?c=d", true},
+		{"unix://relative.sock", "relative.sock", "/", true},
+		{"unix://", "", "/", false},
+		{"ws://127.0.0.1:8080", "", "", false},
+	} {
+		sock, path, ok := parseUnixURL(c.raw)
+		if sock != c.sock || path != c.path || ok != c.ok {
+			t.Errorf("parseUnixURL(%q) = %q, %q, %v, want %q, %q, %v", c.raw, sock, path, ok, c.sock, c.path, c.ok)
+		}
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	for _, c := range []struct {
+		host string
+		port uint64
+		want string
+	}{
+		{"0.0.0.0", 5700, "0.0.0.0:5700"},
+		{"", 6700, ":6700"},
+		{"unix:///run/gocq/api.sock", 5700, "unix:///run/gocq/api.sock"},
+	} {
+		if got := ListenAddr(c.host, c.port); got != c.want {
+			t.Errorf("ListenAddr(%q, %v) = %q, want %q", c.host, c.port, got, c.want)
+		}
+	}
+}
+
+func TestNewListenConfigMode(t *testing.T) {
+	lc, err := newListenConfig("unix:///tmp/a.sock", "0660", "", "", "")
+	if err != nil || lc.mode != 0660 {
+		t.Fatalf("mode = %o, %v", lc.mode, err)
+	}
+	if lc, err = newListenConfig("127.0.0.1:5700", "", "", "", ""); err != nil || lc.mode != 0 {
+		t.Fatalf("mode = %o, %v", lc.mode, err)
+	}
+	if _, err = newListenConfig("unix:///tmp/a.sock", "0999", "", "", ""); err == nil {
+		t.Fatal("invalid mode accepted")
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "api.sock")
+
+	// 模拟上次运行残留的 socket 文件
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix socket unavailable: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+	if !global.PathExists(sock) {
+		t.Fatal("stale socket not left behind")
+	}
+
+	lc, err := newListenConfig(unixPrefix+sock, "0600", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := listen(lc)
+	if err != nil {
+		t.Fatalf("listen over stale socket: %v", err)
+	}
+	defer ln.Close()
+	if info, err := os.Stat(sock); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode = %v, %v", info.Mode().Perm(), err)
+	}
+
+	a := newAuthenticator(&global.JSONConfig{Tokens: []*global.GoCQAPIToken{
+		{Name: "local", Token: "t", Actions: []string{"*"}, AllowIPs: []string{"127.0.0.1"}},
+	}})
+	go func() {
+		_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := a.authorize(r, nil); err != nil {
+				w.WriteHeader(401)
+				return
+			}
+			_, _ = w.Write([]byte(r.URL.Path))
+		}))
+	}()
+
+	_, reqPath, _ := parseUnixURL(unixPrefix + sock + ":/event")
+	resp, err := unixHTTPClient(sock).Get("http://unix" + reqPath + "?access_token=t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(b) != "/event" {
+		t.Fatalf("unexpected response %v %q", resp.StatusCode, b)
+	}
+}
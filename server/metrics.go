@@ -0,0 +1,46 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sam01101/gocq-qqdrive/coolq"
+	"github.com/sam01101/gocq-qqdrive/global"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	apiCalls        = global.NewCounterVec("gocq_api_calls_total", "Number of API calls by action and retcode.", "action", "retcode")
+	apiCallDuration = global.NewHistogramVec("gocq_api_call_duration_seconds", "Latency of API calls by action and retcode.", global.DefaultLatencyBuckets, "action", "retcode")
+	eventPushes     = global.NewCounterVec("gocq_event_pushes_total", "Number of event pushes by destination and outcome.", "destination", "result")
+	reloginAttempts = global.NewCounterVec("gocq_relogin_attempts_total", "Number of relogin attempts by outcome.", "result")
+)
+
+// observeAPICall 记录API调用结果与耗时, 未知的API统一记为 unknown 以限制标签数量
+func observeAPICall(action string, ret coolq.MSG, d time.Duration) {
+	if _, ok := API[action]; !ok {
+		action = "unknown"
+	}
+	code := "0"
+	switch c := ret["retcode"].(type) {
+	case int:
+		code = strconv.Itoa(c)
+	case int64:
+		code = strconv.FormatInt(c, 10)
+	}
+	apiCalls.Inc(action, code)
+	apiCallDuration.Observe(d.Seconds(), action, code)
+}
+
+// metrics 输出 Prometheus 格式的指标
+func (s *webServer) metrics(c *gin.Context) {
+	if scope := scopeFromContext(c); !scope.allow("admin/metrics") {
+		log.Warnf("已拒绝令牌 %v 访问 /metrics: 权限不足", scope.name)
+		c.Status(403)
+		return
+	}
+	c.Header("Content-Type", global.MetricsContentType)
+	c.Status(200)
+	global.WriteMetrics(c.Writer)
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+)
+
+// service 可启动与停止的服务端点
+//
+// HTTP/WebSocket 服务器, 反向WebSocket客户端与HTTP POST上报器均实现此接口, 由 webServer 统一管理
+type service interface {
+	// Start 启动服务, ctx 被取消后服务的后台任务(如重连)将停止
+	Start(ctx context.Context) error
+	// Stop 停止服务并释放资源, ctx 用于限制等待时间
+	Stop(ctx context.Context) error
+}
+
+// connectionStat 端点的活跃连接数
+type connectionStat struct {
+	Endpoint    string `json:"endpoint"`
+	Connections int    `json:"connections"`
+}
+
+// connectionReporter 可统计活跃连接数的服务
+type connectionReporter interface {
+	connectionStats() []connectionStat
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"runtime/debug"
 	"strconv"
@@ -11,6 +10,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
 	"github.com/sam01101/gocq-qqdrive/coolq"
 	"github.com/sam01101/gocq-qqdrive/global"
 	log "github.com/sirupsen/logrus"
@@ -18,21 +18,35 @@ import (
 )
 
 type webSocketServer struct {
+	lc             *listenConfig
 	bot            *coolq.CQBot
-	token          string
+	auth           *authenticator
+	server         *http.Server
 	eventConn      []*webSocketConn
 	eventConnMutex sync.Mutex
-	handshake      string
+	conns          map[*webSocketConn]string // 连接 -> 路径
+	connsMutex     sync.Mutex
+	sub            *coolq.Subscription
 }
 
 // WebSocketClient WebSocket客户端实例
 type WebSocketClient struct {
-	conf  *global.GoCQReverseWebSocketConfig
-	token string
-	bot   *coolq.CQBot
+	conf   *global.GoCQReverseWebSocketConfig
+	token  string
+	bot    *coolq.CQBot
+	dialer *websocket.Dialer
 
+	ctx    context.Context
+	cancel context.CancelFunc
+	sub    *coolq.Subscription
+
+	connsMutex    sync.Mutex // 保护以下连接, 连接与重连在后台进行
 	universalConn *webSocketConn
 	eventConn     *webSocketConn
+	apiConn       *webSocketConn
+
+	connectedMutex sync.Mutex
+	connected      map[string]bool // 角色 -> 是否已连接
 }
 
 type webSocketConn struct {
@@ -41,27 +55,89 @@ type webSocketConn struct {
 	apiCaller apiCaller
 }
 
-// WebSocketServer 初始化一个WebSocketServer实例
-var WebSocketServer = &webSocketServer{}
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
-func (s *webSocketServer) Run(addr, authToken string, b *coolq.CQBot) {
-	s.token = authToken
-	s.bot = b
-	s.handshake = fmt.Sprintf(`{"_post_method":2,"meta_event_type":"lifecycle","post_type":"meta_event","self_id":%d,"sub_type":"connect","time":%d}`,
-		s.bot.Client.Uin, time.Now().Unix())
-	b.OnEventPush(s.onBotPushEvent)
-	http.HandleFunc("/event", s.event)
-	http.HandleFunc("/api", s.api)
-	http.HandleFunc("/", s.any)
+func newWebSocketServer(lc *listenConfig, auth *authenticator, b *coolq.CQBot) *webSocketServer {
+	return &webSocketServer{lc: lc, auth: auth, bot: b, conns: map[*webSocketConn]string{}}
+}
+
+// handshake 生成连接建立时发送的 lifecycle connect 事件
+func (s *webSocketServer) handshake() string {
+	m := s.bot.Lifecycle(coolq.LifecycleConnect).ToMSG()
+	m["_post_method"] = 2
+	return m.ToJSON()
+}
+
+// Start 开始监听并接受 WebSocket 连接
+func (s *webSocketServer) Start(_ context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/event", s.event)
+	mux.HandleFunc("/api", s.api)
+	mux.HandleFunc("/", s.any)
+	ln, err := listen(s.lc)
+	if err != nil {
+		return errors.Wrapf(err, "CQ WebSocket 服务器 %v 启动失败", s.lc)
+	}
+	s.server = &http.Server{Addr: s.lc.addr, Handler: mux}
+	s.sub = s.bot.Subscribe("ws_server:"+s.lc.addr, s.onBotPushEvent)
+	log.Infof("CQ WebSocket 服务器已启动: %v", s.lc)
 	go func() {
-		log.Infof("CQ WebSocket 服务器已启动: %v", addr)
-		log.Fatal(http.ListenAndServe(addr, nil))
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("CQ WebSocket 服务器运行时出现错误: %v", err)
+		}
 	}()
+	return nil
+}
+
+// Stop 停止监听并关闭所有已建立的连接
+func (s *webSocketServer) Stop(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	s.sub.Unsubscribe()
+	err := s.server.Shutdown(ctx)
+	// Shutdown 不会关闭已升级为 WebSocket 的连接, 需要手动关闭
+	s.connsMutex.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = map[*webSocketConn]string{}
+	s.connsMutex.Unlock()
+	s.eventConnMutex.Lock()
+	s.eventConn = nil
+	s.eventConnMutex.Unlock()
+	log.Infof("CQ WebSocket 服务器已停止: %v", s.lc)
+	return err
+}
+
+func (s *webSocketServer) track(conn *webSocketConn, path string) {
+	s.connsMutex.Lock()
+	s.conns[conn] = path
+	s.connsMutex.Unlock()
+}
+
+func (s *webSocketServer) untrack(conn *webSocketConn) {
+	s.connsMutex.Lock()
+	delete(s.conns, conn)
+	s.connsMutex.Unlock()
+}
+
+func (s *webSocketServer) connectionStats() []connectionStat {
+	count := map[string]int{"/event": 0, "/api": 0, "/": 0}
+	s.connsMutex.Lock()
+	for _, p := range s.conns {
+		count[p]++
+	}
+	s.connsMutex.Unlock()
+	r := make([]connectionStat, 0, len(count))
+	for _, p := range []string{"/", "/api", "/event"} {
+		r = append(r, connectionStat{Endpoint: "ws_server:" + s.lc.addr + p, Connections: count[p]})
+	}
+	return r
 }
 
 // NewWebSocketClient 初始化一个NWebSocket客户端
@@ -69,22 +145,101 @@ func NewWebSocketClient(conf *global.GoCQReverseWebSocketConfig, authToken strin
 	return &WebSocketClient{conf: conf, token: authToken, bot: b}
 }
 
-// Run 运行实例
-func (c *WebSocketClient) Run() {
+// dial 连接到反向WebSocket服务器, 支持 unix:///path/to.sock[:/path] 格式的地址
+func (c *WebSocketClient) dial(addr string, header http.Header) (*websocket.Conn, error) {
+	if sock, reqPath, ok := parseUnixURL(addr); ok {
+		dialer := *c.dialer
+		dialer.NetDialContext = unixDialContext(sock)
+		conn, _, err := dialer.Dial("ws://unix"+reqPath, header)
+		return conn, err
+	}
+	conn, _, err := c.dialer.Dial(addr, header)
+	return conn, err
+}
+
+// Start 开始连接反向WebSocket服务器, 连接在后台进行
+func (c *WebSocketClient) Start(ctx context.Context) error {
 	if !c.conf.Enabled {
-		return
+		return nil
 	}
-	if c.conf.ReverseURL != "" {
-		c.connectUniversal()
-	} else {
-		if c.conf.ReverseAPIURL != "" {
-			c.connectAPI()
+	tlsConfig, err := global.NewClientTLSConfig(c.conf.TLSCA)
+	if err != nil {
+		return errors.Wrapf(err, "加载反向WebSocket CA证书 %v 失败", c.conf.TLSCA)
+	}
+	c.dialer = websocket.DefaultDialer
+	if tlsConfig != nil {
+		dialer := *websocket.DefaultDialer
+		dialer.TLSClientConfig = tlsConfig
+		c.dialer = &dialer
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	go func() {
+		if c.conf.ReverseURL != "" {
+			c.connectUniversal()
+		} else {
+			if c.conf.ReverseAPIURL != "" {
+				c.connectAPI()
+			}
+			if c.conf.ReverseEventURL != "" {
+				c.connectEvent()
+			}
 		}
-		if c.conf.ReverseEventURL != "" {
-			c.connectEvent()
+	}()
+	name := c.conf.ReverseURL
+	if name == "" {
+		name = c.conf.ReverseEventURL
+	}
+	c.sub = c.bot.Subscribe("ws_reverse:"+name, c.onBotPushEvent)
+	return nil
+}
+
+// Stop 断开所有连接并停止重连
+func (c *WebSocketClient) Stop(_ context.Context) error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+	c.sub.Unsubscribe()
+	c.connsMutex.Lock()
+	conns := []*webSocketConn{c.universalConn, c.eventConn, c.apiConn}
+	c.connsMutex.Unlock()
+	for _, conn := range conns {
+		if conn != nil {
+			_ = conn.Close()
 		}
 	}
-	c.bot.OnEventPush(c.onBotPushEvent)
+	return nil
+}
+
+// setConn 保存新建立的连接, 客户端已停止时关闭该连接并返回false
+func (c *WebSocketClient) setConn(p **webSocketConn, conn *webSocketConn) bool {
+	c.connsMutex.Lock()
+	defer c.connsMutex.Unlock()
+	if c.ctx.Err() != nil {
+		_ = conn.Close()
+		return false
+	}
+	*p = conn
+	return true
+}
+
+func (c *WebSocketClient) getConn(p **webSocketConn) *webSocketConn {
+	c.connsMutex.Lock()
+	defer c.connsMutex.Unlock()
+	return *p
+}
+
+// waitReconnect 等待重连间隔, 未设置重连或客户端已停止时返回false
+func (c *WebSocketClient) waitReconnect() bool {
+	if c.conf.ReverseReconnectInterval == 0 {
+		return false
+	}
+	select {
+	case <-c.ctx.Done():
+		return false
+	case <-time.After(time.Millisecond * time.Duration(c.conf.ReverseReconnectInterval)):
+		return c.ctx.Err() == nil
+	}
 }
 
 func (c *WebSocketClient) connectAPI() {
@@ -97,17 +252,20 @@ func (c *WebSocketClient) connectAPI() {
 	if c.token != "" {
 		header["Authorization"] = []string{"Token " + c.token}
 	}
-	conn, _, err := websocket.DefaultDialer.Dial(c.conf.ReverseAPIURL, header)
+	conn, err := c.dial(c.conf.ReverseAPIURL, header)
 	if err != nil {
 		log.Warnf("连接到反向WebSocket API服务器 %v 时出现错误: %v", c.conf.ReverseAPIURL, err)
-		if c.conf.ReverseReconnectInterval != 0 {
-			time.Sleep(time.Millisecond * time.Duration(c.conf.ReverseReconnectInterval))
+		if c.waitReconnect() {
 			c.connectAPI()
 		}
 		return
 	}
+	wrappedConn := &webSocketConn{Conn: conn, apiCaller: apiCaller{bot: c.bot}}
+	if !c.setConn(&c.apiConn, wrappedConn) {
+		return
+	}
 	log.Infof("已连接到反向WebSocket API服务器 %v", c.conf.ReverseAPIURL)
-	wrappedConn := &webSocketConn{Conn: conn, apiCaller: apiCaller{c.bot}}
+	c.setConnected("API", true)
 	go c.listenAPI(wrappedConn, false)
 }
 
@@ -121,25 +279,25 @@ func (c *WebSocketClient) connectEvent() {
 	if c.token != "" {
 		header["Authorization"] = []string{"Token " + c.token}
 	}
-	conn, _, err := websocket.DefaultDialer.Dial(c.conf.ReverseEventURL, header)
+	conn, err := c.dial(c.conf.ReverseEventURL, header)
 	if err != nil {
 		log.Warnf("连接到反向WebSocket Event服务器 %v 时出现错误: %v", c.conf.ReverseEventURL, err)
-		if c.conf.ReverseReconnectInterval != 0 {
-			time.Sleep(time.Millisecond * time.Duration(c.conf.ReverseReconnectInterval))
+		if c.waitReconnect() {
 			c.connectEvent()
 		}
 		return
 	}
 
-	handshake := fmt.Sprintf(`{"meta_event_type":"lifecycle","post_type":"meta_event","self_id":%d,"sub_type":"connect","time":%d}`,
-		c.bot.Client.Uin, time.Now().Unix())
-	err = conn.WriteMessage(websocket.TextMessage, []byte(handshake))
+	err = conn.WriteMessage(websocket.TextMessage, []byte(c.bot.Lifecycle(coolq.LifecycleConnect).ToMSG().ToJSON()))
 	if err != nil {
 		log.Warnf("反向WebSocket 握手时出现错误: %v", err)
 	}
 
+	if !c.setConn(&c.eventConn, &webSocketConn{Conn: conn, apiCaller: apiCaller{bot: c.bot}}) {
+		return
+	}
 	log.Infof("已连接到反向WebSocket Event服务器 %v", c.conf.ReverseEventURL)
-	c.eventConn = &webSocketConn{Conn: conn, apiCaller: apiCaller{c.bot}}
+	c.setConnected("Event", true)
 }
 
 func (c *WebSocketClient) connectUniversal() {
@@ -152,26 +310,59 @@ func (c *WebSocketClient) connectUniversal() {
 	if c.token != "" {
 		header["Authorization"] = []string{"Token " + c.token}
 	}
-	conn, _, err := websocket.DefaultDialer.Dial(c.conf.ReverseURL, header)
+	conn, err := c.dial(c.conf.ReverseURL, header)
 	if err != nil {
 		log.Warnf("连接到反向WebSocket Universal服务器 %v 时出现错误: %v", c.conf.ReverseURL, err)
-		if c.conf.ReverseReconnectInterval != 0 {
-			time.Sleep(time.Millisecond * time.Duration(c.conf.ReverseReconnectInterval))
+		if c.waitReconnect() {
 			c.connectUniversal()
 		}
 		return
 	}
 
-	handshake := fmt.Sprintf(`{"meta_event_type":"lifecycle","post_type":"meta_event","self_id":%d,"sub_type":"connect","time":%d}`,
-		c.bot.Client.Uin, time.Now().Unix())
-	err = conn.WriteMessage(websocket.TextMessage, []byte(handshake))
+	err = conn.WriteMessage(websocket.TextMessage, []byte(c.bot.Lifecycle(coolq.LifecycleConnect).ToMSG().ToJSON()))
 	if err != nil {
 		log.Warnf("反向WebSocket 握手时出现错误: %v", err)
 	}
 
-	wrappedConn := &webSocketConn{Conn: conn, apiCaller: apiCaller{c.bot}}
+	wrappedConn := &webSocketConn{Conn: conn, apiCaller: apiCaller{bot: c.bot}}
+	if !c.setConn(&c.universalConn, wrappedConn) {
+		return
+	}
 	go c.listenAPI(wrappedConn, true)
-	c.universalConn = wrappedConn
+	c.setConnected("Universal", true)
+}
+
+func (c *WebSocketClient) setConnected(role string, connected bool) {
+	c.connectedMutex.Lock()
+	if c.connected == nil {
+		c.connected = map[string]bool{}
+	}
+	c.connected[role] = connected
+	c.connectedMutex.Unlock()
+}
+
+func (c *WebSocketClient) connectionStats() []connectionStat {
+	var r []connectionStat
+	c.connectedMutex.Lock()
+	defer c.connectedMutex.Unlock()
+	add := func(role, u string) {
+		n := 0
+		if c.connected[role] {
+			n = 1
+		}
+		r = append(r, connectionStat{Endpoint: "ws_reverse:" + u, Connections: n})
+	}
+	if c.conf.ReverseURL != "" {
+		add("Universal", c.conf.ReverseURL)
+		return r
+	}
+	if c.conf.ReverseAPIURL != "" {
+		add("API", c.conf.ReverseAPIURL)
+	}
+	if c.conf.ReverseEventURL != "" {
+		add("Event", c.conf.ReverseEventURL)
+	}
+	return r
 }
 
 func (c *WebSocketClient) listenAPI(conn *webSocketConn, u bool) {
@@ -180,69 +371,75 @@ func (c *WebSocketClient) listenAPI(conn *webSocketConn, u bool) {
 		_, buf, err := conn.ReadMessage()
 		if err != nil {
 			log.Warnf("监听反向WS API时出现错误: %v", err)
+			if u {
+				c.setConnected("Universal", false)
+			} else {
+				c.setConnected("API", false)
+			}
 			break
 		}
 
 		go conn.handleRequest(c.bot, buf)
 
 	}
-	if c.conf.ReverseReconnectInterval != 0 {
-		time.Sleep(time.Millisecond * time.Duration(c.conf.ReverseReconnectInterval))
-		if !u {
-			go c.connectAPI()
-		}
+	if !u && c.waitReconnect() {
+		go c.connectAPI()
 	}
 }
 
 func (c *WebSocketClient) onBotPushEvent(m coolq.MSG) {
-	if c.eventConn != nil {
-		log.Debugf("向WS服务器 %v 推送Event: %v", c.eventConn.RemoteAddr().String(), m.ToJSON())
-		conn := c.eventConn
-		conn.Lock()
-		defer conn.Unlock()
-		_ = c.eventConn.SetWriteDeadline(time.Now().Add(time.Second * 15))
-		if err := c.eventConn.WriteJSON(m); err != nil {
-			log.Warnf("向WS服务器 %v 推送Event时出现错误: %v", c.eventConn.RemoteAddr().String(), err)
-			_ = c.eventConn.Close()
-			if c.conf.ReverseReconnectInterval != 0 {
-				time.Sleep(time.Millisecond * time.Duration(c.conf.ReverseReconnectInterval))
-				c.connectEvent()
-			}
-		}
+	c.pushEvent(&c.eventConn, m, "Event", c.conf.ReverseEventURL, c.connectEvent)
+	c.pushEvent(&c.universalConn, m, "Universal", c.conf.ReverseURL, c.connectUniversal)
+}
+
+// pushEvent 向 *p 中的连接推送事件, 推送失败时关闭连接并在后台重连
+func (c *WebSocketClient) pushEvent(p **webSocketConn, m coolq.MSG, role, u string, reconnect func()) {
+	conn := c.getConn(p)
+	if conn == nil {
+		return
 	}
-	if c.universalConn != nil {
-		log.Debugf("向WS服务器 %v 推送Event: %v", c.universalConn.RemoteAddr().String(), m.ToJSON())
-		conn := c.universalConn
-		conn.Lock()
-		defer conn.Unlock()
-		_ = c.universalConn.SetWriteDeadline(time.Now().Add(time.Second * 15))
-		if err := c.universalConn.WriteJSON(m); err != nil {
-			log.Warnf("向WS服务器 %v 推送Event时出现错误: %v", c.universalConn.RemoteAddr().String(), err)
-			_ = c.universalConn.Close()
-			if c.conf.ReverseReconnectInterval != 0 {
-				time.Sleep(time.Millisecond * time.Duration(c.conf.ReverseReconnectInterval))
-				c.connectUniversal()
-			}
-		}
+	log.Debugf("向WS服务器 %v 推送Event: %v", conn.RemoteAddr().String(), m.ToJSON())
+	conn.Lock()
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+	err := conn.WriteJSON(m)
+	conn.Unlock()
+	eventPushes.Inc("ws_reverse:"+u, global.ResultLabel(err))
+	if err == nil {
+		return
+	}
+	log.Warnf("向WS服务器 %v 推送Event时出现错误: %v", conn.RemoteAddr().String(), err)
+	_ = conn.Close()
+	// 只由第一个发现连接失效的推送负责重连
+	c.connsMutex.Lock()
+	owner := *p == conn
+	if owner {
+		*p = nil
 	}
+	c.connsMutex.Unlock()
+	if !owner {
+		return
+	}
+	c.setConnected(role, false)
+	go func() {
+		if c.waitReconnect() {
+			reconnect()
+		}
+	}()
 }
 
 func (s *webSocketServer) event(w http.ResponseWriter, r *http.Request) {
-	if s.token != "" {
-		if auth := r.URL.Query().Get("access_token"); auth != s.token {
-			if auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(auth) != 2 || auth[1] != s.token {
-				log.Warnf("已拒绝 %v 的 WebSocket 请求: Token鉴权失败", r.RemoteAddr)
-				w.WriteHeader(401)
-				return
-			}
-		}
+	scope, err := s.auth.authorize(r, nil)
+	if err != nil {
+		log.Warnf("已拒绝 %v 的 WebSocket 请求: 鉴权失败 (%v)", r.RemoteAddr, err)
+		w.WriteHeader(401)
+		return
 	}
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Warnf("处理 WebSocket 请求时出现错误: %v", err)
 		return
 	}
-	err = c.WriteMessage(websocket.TextMessage, []byte(s.handshake))
+	err = c.WriteMessage(websocket.TextMessage, []byte(s.handshake()))
 	if err != nil {
 		log.Warnf("WebSocket 握手时出现错误: %v", err)
 		c.Close()
@@ -251,7 +448,8 @@ func (s *webSocketServer) event(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("接受 WebSocket 连接: %v (/event)", r.RemoteAddr)
 
-	conn := &webSocketConn{Conn: c, apiCaller: apiCaller{s.bot}}
+	conn := &webSocketConn{Conn: c, apiCaller: apiCaller{bot: s.bot, scope: scope}}
+	s.track(conn, "/event")
 
 	s.eventConnMutex.Lock()
 	s.eventConn = append(s.eventConn, conn)
@@ -259,14 +457,11 @@ func (s *webSocketServer) event(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *webSocketServer) api(w http.ResponseWriter, r *http.Request) {
-	if s.token != "" {
-		if auth := r.URL.Query().Get("access_token"); auth != s.token {
-			if auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(auth) != 2 || auth[1] != s.token {
-				log.Warnf("已拒绝 %v 的 WebSocket 请求: Token鉴权失败", r.RemoteAddr)
-				w.WriteHeader(401)
-				return
-			}
-		}
+	scope, err := s.auth.authorize(r, nil)
+	if err != nil {
+		log.Warnf("已拒绝 %v 的 WebSocket 请求: 鉴权失败 (%v)", r.RemoteAddr, err)
+		w.WriteHeader(401)
+		return
 	}
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -274,38 +469,40 @@ func (s *webSocketServer) api(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Infof("接受 WebSocket 连接: %v (/api)", r.RemoteAddr)
-	conn := &webSocketConn{Conn: c, apiCaller: apiCaller{s.bot}}
+	conn := &webSocketConn{Conn: c, apiCaller: apiCaller{bot: s.bot, scope: scope}}
+	s.track(conn, "/api")
 	go s.listenAPI(conn)
 }
 
 func (s *webSocketServer) any(w http.ResponseWriter, r *http.Request) {
-	if s.token != "" {
-		if auth := r.URL.Query().Get("access_token"); auth != s.token {
-			if auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(auth) != 2 || auth[1] != s.token {
-				log.Warnf("已拒绝 %v 的 WebSocket 请求: Token鉴权失败", r.RemoteAddr)
-				w.WriteHeader(401)
-				return
-			}
-		}
+	scope, err := s.auth.authorize(r, nil)
+	if err != nil {
+		log.Warnf("已拒绝 %v 的 WebSocket 请求: 鉴权失败 (%v)", r.RemoteAddr, err)
+		w.WriteHeader(401)
+		return
 	}
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Warnf("处理 WebSocket 请求时出现错误: %v", err)
 		return
 	}
-	err = c.WriteMessage(websocket.TextMessage, []byte(s.handshake))
+	err = c.WriteMessage(websocket.TextMessage, []byte(s.handshake()))
 	if err != nil {
 		log.Warnf("WebSocket 握手时出现错误: %v", err)
 		c.Close()
 		return
 	}
 	log.Infof("接受 WebSocket 连接: %v (/)", r.RemoteAddr)
-	conn := &webSocketConn{Conn: c, apiCaller: apiCaller{s.bot}}
+	conn := &webSocketConn{Conn: c, apiCaller: apiCaller{bot: s.bot, scope: scope}}
+	s.track(conn, "/")
+	s.eventConnMutex.Lock()
 	s.eventConn = append(s.eventConn, conn)
+	s.eventConnMutex.Unlock()
 	s.listenAPI(conn)
 }
 
 func (s *webSocketServer) listenAPI(c *webSocketConn) {
+	defer s.untrack(c)
 	defer c.Close()
 	for {
 		t, payload, err := c.ReadMessage()
@@ -326,11 +523,19 @@ func (c *webSocketConn) handleRequest(_ *coolq.CQBot, payload []byte) {
 			c.Close()
 		}
 	}()
-	global.RateLimit(context.Background())
 	j := gjson.ParseBytes(payload)
+	fields := log.Fields{
+		"request_id":  coolq.NewRequestID(),
+		"remote_addr": c.RemoteAddr().String(),
+	}
+	if echo := j.Get("echo"); echo.Exists() {
+		fields["echo"] = echo.String()
+	}
+	ctx := coolq.WithLogFields(context.Background(), fields)
+	global.RateLimit(ctx)
 	t := strings.ReplaceAll(j.Get("action").Str, "_async", "")
-	log.Debugf("WS接收到API调用: %v 参数: %v", t, j.Get("params").Raw)
-	ret := c.apiCaller.callAPI(t, j.Get("params"))
+	coolq.Logger(ctx).Debugf("WS接收到API调用: %v 参数: %v", t, j.Get("params").Raw)
+	ret := c.apiCaller.callAPI(ctx, t, j.Get("params"))
 	if j.Get("echo").Exists() {
 		ret["echo"] = j.Get("echo").Value()
 	}
@@ -346,8 +551,11 @@ func (s *webSocketServer) onBotPushEvent(m coolq.MSG) {
 		conn := s.eventConn[i]
 		log.Debugf("向WS客户端 %v 推送Event: %v", conn.RemoteAddr().String(), m.ToJSON())
 		conn.Lock()
-		if err := conn.WriteMessage(websocket.TextMessage, []byte(m.ToJSON())); err != nil {
+		err := conn.WriteMessage(websocket.TextMessage, []byte(m.ToJSON()))
+		eventPushes.Inc("ws_server:"+s.lc.addr, global.ResultLabel(err))
+		if err != nil {
 			_ = conn.Close()
+			s.untrack(conn)
 			next := i + 1
 			if next >= l {
 				next = l - 1
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sam01101/MiraiGo-qdrive/client"
+
+	"github.com/sam01101/gocq-qqdrive/coolq"
+	"github.com/sam01101/gocq-qqdrive/global"
+)
+
+func TestWebSocketClientStopDuringDial(t *testing.T) {
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	bot := coolq.NewQQBot(&client.QQClient{Uin: 10000}, &global.JSONConfig{})
+	defer bot.Close()
+	c := NewWebSocketClient(&global.GoCQReverseWebSocketConfig{
+		Enabled:       true,
+		ReverseAPIURL: "ws" + strings.TrimPrefix(ts.URL, "http"),
+	}, "", bot)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	_ = c.Stop(context.Background())
+	close(release)
+
+	// Stop 之后才建立的连接也应被关闭
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 3):
+		t.Fatal("connection established after Stop was not closed")
+	}
+}
+
+func TestWebSocketClientBadCA(t *testing.T) {
+	c := NewWebSocketClient(&global.GoCQReverseWebSocketConfig{
+		Enabled:    true,
+		ReverseURL: "wss://127.0.0.1:1/",
+		TLSCA:      filepath.Join(t.TempDir(), "missing.pem"),
+	}, "", nil)
+	if err := c.Start(context.Background()); err == nil {
+		t.Fatal("Start should fail when tls_ca cannot be loaded")
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebSocketClientPushReconnect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	c := &WebSocketClient{conf: &global.GoCQReverseWebSocketConfig{ReverseReconnectInterval: 10}}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+	c.eventConn = &webSocketConn{Conn: conn}
+	reconnects := make(chan struct{}, 2)
+	reconnect := func() { reconnects <- struct{}{} }
+
+	// 推送失败后应释放连接锁并在后台重连, 且只重连一次
+	c.pushEvent(&c.eventConn, nil, "Event", "", reconnect)
+	c.pushEvent(&c.eventConn, nil, "Event", "", reconnect)
+	if c.getConn(&c.eventConn) != nil {
+		t.Fatal("failed connection was not cleared")
+	}
+	select {
+	case <-reconnects:
+	case <-time.After(time.Second * 3):
+		t.Fatal("no reconnect after push failure")
+	}
+	select {
+	case <-reconnects:
+		t.Fatal("push failure reconnected more than once")
+	case <-time.After(time.Millisecond * 100):
+	}
+}